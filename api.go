@@ -0,0 +1,395 @@
+// api.go
+// Control socket: a root service that reads and applies settings, manages profiles and persistence
+// on behalf of unprivileged clients such as the GUI, speaking JSON over a Unix socket.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	apiSocketPath = "/run/undervolt-go.sock"
+	apiGroup      = "undervolt-go" // members may use the socket
+)
+
+// Operations of the control socket.
+const (
+	apiOpRead              = "read"                // read the live settings (Report)
+	apiOpApply             = "apply"               // apply Profile, with Force and Persist
+	apiOpProfileList       = "profile.list"        // names of the saved profiles (Profiles)
+	apiOpProfileGet        = "profile.get"         // saved profile Name (Profile)
+	apiOpProfileSave       = "profile.save"        // save Profile as Name
+	apiOpProfileDelete     = "profile.delete"      // delete profile Name
+	apiOpProfileApply      = "profile.apply"       // apply profile Name, or "auto", with Force
+	apiOpPersistDisable    = "persist.disable"     // remove the boot/resume service
+	apiOpAutoSwitchEnable  = "auto_switch.enable"  // enable auto-switch, mapping AC and Battery when given
+	apiOpAutoSwitchDisable = "auto_switch.disable" // disable auto-switch
+)
+
+// errAPIUnreachable is returned by apiCall when no service listens on the socket,
+// or the caller may not connect to it.
+var errAPIUnreachable = errors.New("cannot reach the undervolt-go service")
+
+// APIRequest is one request on the control socket. Requests and responses are
+// JSON objects, one per line; a connection may carry any number of them.
+type APIRequest struct {
+	Op      string   `json:"op"`
+	Name    string   `json:"name,omitempty"`
+	Profile *Profile `json:"profile,omitempty"`
+	Force   bool     `json:"force,omitempty"`   // allow positive offsets
+	Persist bool     `json:"persist,omitempty"` // also apply the settings on boot and resume
+	AC      string   `json:"ac,omitempty"`
+	Battery string   `json:"battery,omitempty"`
+}
+
+// APIResponse answers an APIRequest. On failure OK is false and Error says why.
+type APIResponse struct {
+	OK       bool     `json:"ok"`
+	Error    string   `json:"error,omitempty"`
+	Message  string   `json:"message,omitempty"`
+	Report   *Report  `json:"report,omitempty"`
+	Profiles []string `json:"profiles,omitempty"`
+	Profile  *Profile `json:"profile,omitempty"`
+}
+
+// ---------- Server ----------
+
+// apiServer executes requests one at a time, as applying goes through the
+// global flag variables.
+type apiServer struct {
+	mu    sync.Mutex
+	dev   MSRDevice
+	group string // members are authorized
+	gid   uint32
+}
+
+// peerCredentials returns the process credentials of the other end of conn.
+func peerCredentials(conn *net.UnixConn) (*syscall.Ucred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	return cred, credErr
+}
+
+// authorize allows root and the members of the group, primary or supplementary.
+func (s *apiServer) authorize(cred *syscall.Ucred) error {
+	if cred.Uid == 0 || cred.Gid == s.gid {
+		return nil
+	}
+	if u, err := user.LookupId(strconv.Itoa(int(cred.Uid))); err == nil {
+		if groups, err := u.GroupIds(); err == nil && slices.Contains(groups, strconv.Itoa(int(s.gid))) {
+			return nil
+		}
+	}
+	return fmt.Errorf("permission denied: uid %d is not a member of the %s group", cred.Uid, s.group)
+}
+
+func (s *apiServer) serveConn(conn *net.UnixConn) {
+	defer conn.Close()
+	enc := json.NewEncoder(conn)
+	cred, err := peerCredentials(conn)
+	if err == nil {
+		err = s.authorize(cred)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Rejected connection: %v\n", err)
+		enc.Encode(APIResponse{Error: err.Error()})
+		return
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		var req APIRequest
+		if err := dec.Decode(&req); err != nil {
+			if err != io.EOF {
+				enc.Encode(APIResponse{Error: "invalid request: " + err.Error()})
+			}
+			return
+		}
+		origin := &changeOrigin{User: uidUser(cred.Uid), Command: "serve: " + req.Op}
+		resp := s.handle(req, origin)
+		if resp.OK {
+			fmt.Fprintf(os.Stderr, "%s (uid %d): %s\n", origin.User, cred.Uid, req.Op)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s (uid %d): %s failed: %s\n", origin.User, cred.Uid, req.Op, resp.Error)
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// handle executes one request, recording changes for origin (nil for the user running the command).
+func (s *apiServer) handle(req APIRequest, origin *changeOrigin) APIResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requestOrigin = origin
+	defer func() { requestOrigin = nil }()
+	resp, err := s.dispatch(req)
+	if err != nil {
+		return APIResponse{Error: err.Error()}
+	}
+	resp.OK = true
+	return resp
+}
+
+func (s *apiServer) dispatch(req APIRequest) (APIResponse, error) {
+	var resp APIResponse
+	switch req.Op {
+	case apiOpRead:
+		report, err := readReport(s.dev, ADDRESSES)
+		if err != nil {
+			return resp, err
+		}
+		resp.Report = &report
+
+	case apiOpApply:
+		if req.Profile == nil {
+			return resp, fmt.Errorf("%s needs a profile", req.Op)
+		}
+		if err := req.Profile.validate(); err != nil {
+			return resp, err
+		}
+		if err := applyProfile(s.dev, req.Profile, req.Force); err != nil {
+			return resp, err
+		}
+		resp.Message = "Settings applied."
+		if req.Persist {
+			args := req.Profile.args()
+			if req.Force {
+				args = append(args, "--force")
+			}
+			if err := enablePersistence(args); err != nil {
+				return resp, fmt.Errorf("settings applied, but enabling persistence failed: %w", err)
+			}
+			resp.Message = "Settings applied and persisted."
+		}
+
+	case apiOpProfileList:
+		cfg, err := loadConfig()
+		if err != nil {
+			return resp, err
+		}
+		resp.Profiles = cfg.profileNames()
+
+	case apiOpProfileGet:
+		cfg, err := loadConfig()
+		if err != nil {
+			return resp, err
+		}
+		if resp.Profile, err = cfg.profile(strings.ToLower(req.Name)); err != nil {
+			return resp, err
+		}
+
+	case apiOpProfileSave:
+		name := strings.ToLower(req.Name)
+		if err := checkProfileName(name); err != nil {
+			return resp, err
+		}
+		if req.Profile == nil {
+			return resp, fmt.Errorf("%s needs a profile", req.Op)
+		}
+		if err := req.Profile.validate(); err != nil {
+			return resp, err
+		}
+		cfg, err := loadConfig()
+		if err != nil {
+			return resp, err
+		}
+		cfg.Profiles[name] = req.Profile
+		if err := cfg.save(); err != nil {
+			return resp, fmt.Errorf("error saving config: %w", err)
+		}
+		resp.Message = fmt.Sprintf("Profile '%s' saved.", name)
+
+	case apiOpProfileDelete:
+		name := strings.ToLower(req.Name)
+		if err := deleteProfile(name); err != nil {
+			return resp, err
+		}
+		resp.Message = fmt.Sprintf("Profile '%s' deleted.", name)
+
+	case apiOpProfileApply:
+		cfg, err := loadConfig()
+		if err != nil {
+			return resp, err
+		}
+		name := strings.ToLower(req.Name)
+		if name == "auto" {
+			name = cfg.autoSwitchProfile(currentPowerSource())
+		}
+		p, err := cfg.profile(name)
+		if err != nil {
+			return resp, err
+		}
+		if err := applyProfile(s.dev, p, req.Force); err != nil {
+			return resp, err
+		}
+		resp.Message = fmt.Sprintf("Profile '%s' applied.", name)
+
+	case apiOpPersistDisable:
+		if err := disablePersistence(); err != nil {
+			return resp, err
+		}
+		resp.Message = "Persistence disabled."
+
+	case apiOpAutoSwitchEnable:
+		if err := enableAutoSwitch(req.AC, req.Battery); err != nil {
+			return resp, err
+		}
+		resp.Message = "Auto-switch enabled."
+
+	case apiOpAutoSwitchDisable:
+		disableAutoSwitch()
+		resp.Message = "Auto-switch disabled."
+
+	default:
+		return resp, fmt.Errorf("unknown operation %q", req.Op)
+	}
+	return resp, nil
+}
+
+// applyProfile writes the settings of p to the hardware, through the flag
+// variables. Callers serialize the calls.
+func applyProfile(dev MSRDevice, p *Profile, force bool) error {
+	clearSettingFlags()
+	p.setFlags()
+	if !flagsModifyHardware() {
+		return fmt.Errorf("the profile has no settings to apply")
+	}
+	forceFlag = force
+	defer func() { forceFlag = false }()
+	if err := applyFlags(dev); err != nil {
+		return fmt.Errorf("failed to apply settings: %w", err)
+	}
+	return nil
+}
+
+// listenAPISocket creates the socket at path, accessible to root and the group only.
+func listenAPISocket(path string, gid uint32) (*net.UnixListener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another service is listening on %s", path)
+		}
+		os.Remove(path) // left over from a service that did not stop cleanly
+	}
+	// Create the socket without group and other access, then open it to the group
+	old := syscall.Umask(0o177)
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	syscall.Umask(old)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chown(path, 0, int(gid)); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// ---------- Client ----------
+
+// apiCall sends one request to the service listening on path.
+func apiCall(path string, req APIRequest) (APIResponse, error) {
+	var resp APIResponse
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return resp, fmt.Errorf("%w at %s (is 'undervolt-go serve' running?): %w", errAPIUnreachable, path, err)
+	}
+	defer conn.Close()
+	// Do not hang the client on a stuck service
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return resp, err
+	}
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return resp, fmt.Errorf("no response from the undervolt-go service: %w", err)
+	}
+	if !resp.OK {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// ---------- Serve Command ----------
+
+var apiSocketFlag, apiGroupFlag string
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run the control service that lets members of a group change settings without sudo",
+	Long: "Listen on a Unix socket for JSON requests to read the settings, apply settings or profiles, manage " +
+		"profiles, persistence and auto-switch. The socket is owned by root and the --group, with mode 0660, " +
+		"and every connection is checked against the credentials of the connecting process: only root and " +
+		"members of the group are served. The GUI uses this service.",
+	Example: "  sudo groupadd --system undervolt-go\n  sudo usermod -aG undervolt-go $USER\n  sudo undervolt-go serve",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		group, err := user.LookupGroup(apiGroupFlag)
+		if err != nil {
+			return fmt.Errorf("group %q: %w (create it with 'groupadd --system %s')", apiGroupFlag, err, apiGroupFlag)
+		}
+		gid, err := strconv.ParseUint(group.Gid, 10, 32)
+		if err != nil {
+			return fmt.Errorf("group %q has a non-numeric id %q", apiGroupFlag, group.Gid)
+		}
+		setupLogging()
+		s := &apiServer{dev: openMSRDevice(), group: apiGroupFlag, gid: uint32(gid)}
+		l, err := listenAPISocket(apiSocketFlag, s.gid)
+		if err != nil {
+			return fmt.Errorf("could not listen on %s: %w", apiSocketFlag, err)
+		}
+
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(stop)
+		go func() {
+			<-stop
+			l.Close()
+		}()
+		fmt.Fprintf(os.Stderr, "Serving %s for root and group %s\n", apiSocketFlag, apiGroupFlag)
+		for {
+			conn, err := l.AcceptUnix()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return nil
+				}
+				return err
+			}
+			go s.serveConn(conn)
+		}
+	},
+}
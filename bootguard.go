@@ -0,0 +1,312 @@
+// bootguard.go
+// Boot guard: skips the persisted settings when the boot that last applied them never stayed up long
+// enough to confirm them, so that an unstable undervolt cannot lock up the machine on every boot.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	bootGuardDir         = "/var/lib/undervolt-go"
+	bootGuardServiceName = "undervolt-go-boot-guard.service"
+	bootGuardServicePath = "/etc/systemd/system/" + bootGuardServiceName
+	bootGuardTimerName   = "undervolt-go-boot-guard.timer"
+	bootGuardTimerPath   = "/etc/systemd/system/" + bootGuardTimerName
+
+	bootIDPath = "/proc/sys/kernel/random/boot_id"
+	uptimePath = "/proc/uptime"
+)
+
+// States of the boot guard.
+const (
+	bootGuardApplying = "applying" // the settings were applied in the boot and are not confirmed yet
+	bootGuardStable   = "stable"   // the boot stayed up with the settings long enough
+	bootGuardTripped  = "tripped"  // an earlier boot never confirmed the settings; they are not applied
+)
+
+// bootGuardState is kept in boot-guard.json in the state directory.
+type bootGuardState struct {
+	State  string        `json:"state"`
+	BootID string        `json:"boot_id"`
+	Uptime time.Duration `json:"uptime"` // uptime of the boot when the state was entered
+	Time   time.Time     `json:"time"`   // wall clock time, for messages only
+}
+
+// bootGuard keeps its state in dir. The boot ID and the clocks are functions so
+// that they can be replaced, e.g. to simulate reboots.
+type bootGuard struct {
+	dir    string
+	bootID func() (string, error)
+	uptime func() (time.Duration, error) // time since boot, including suspend
+	now    func() time.Time
+}
+
+func newBootGuard() *bootGuard {
+	return &bootGuard{dir: bootGuardDir, bootID: readBootID, uptime: readUptime, now: time.Now}
+}
+
+func readBootID() (string, error) {
+	data, err := os.ReadFile(bootIDPath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// readUptime reads the boot time clock, which keeps counting during suspend.
+func readUptime() (time.Duration, error) {
+	data, err := os.ReadFile(uptimePath)
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("%s is empty", uptimePath)
+	}
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", uptimePath, err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func (g *bootGuard) path() string {
+	return filepath.Join(g.dir, "boot-guard.json")
+}
+
+// load returns the stored state, or nil when there is none.
+func (g *bootGuard) load() (*bootGuardState, error) {
+	data, err := os.ReadFile(g.path())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st bootGuardState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("%s: %w", g.path(), err)
+	}
+	return &st, nil
+}
+
+// enter stores state for the current boot. The file is synced, as the machine
+// may lock up right after the settings are written.
+func (g *bootGuard) enter(state, bootID string) error {
+	up, err := g.uptime()
+	if err != nil {
+		return fmt.Errorf("could not read the uptime: %w", err)
+	}
+	data, err := json.Marshal(bootGuardState{State: state, BootID: bootID, Uptime: up, Time: g.now()})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(g.dir, 0755); err != nil {
+		return err
+	}
+	tmp := g.path() + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, g.path()); err != nil {
+		return err
+	}
+	if d, err := os.Open(g.dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// status returns the state seen from the current boot, with the stored state
+// (nil when there is none): an "applying" state of another boot is "tripped".
+func (g *bootGuard) status() (string, *bootGuardState, error) {
+	st, err := g.load()
+	if err != nil || st == nil {
+		return "", st, err
+	}
+	id, err := g.bootID()
+	if err != nil {
+		return "", st, fmt.Errorf("could not read the boot ID: %w", err)
+	}
+	if st.State == bootGuardApplying && st.BootID != id {
+		return bootGuardTripped, st, nil
+	}
+	return st.State, st, nil
+}
+
+// errBootGuardTripped is returned by begin when the settings must not be applied.
+var errBootGuardTripped = errors.New("the boot guard tripped")
+
+// begin is run before the persisted settings are applied. It marks the current
+// boot as applying them, unless it did already (resume), and fails with
+// errBootGuardTripped when an earlier boot marked them and never confirmed them.
+func (g *bootGuard) begin() error {
+	state, st, err := g.status()
+	if err != nil {
+		return err
+	}
+	if state == bootGuardTripped {
+		return fmt.Errorf("%w: the boot at %s applied the persisted settings and did not stay up to confirm them",
+			errBootGuardTripped, st.Time.Format(time.RFC3339))
+	}
+	id, err := g.bootID()
+	if err != nil {
+		return fmt.Errorf("could not read the boot ID: %w", err)
+	}
+	if st != nil && st.BootID == id {
+		return nil
+	}
+	return g.enter(bootGuardApplying, id)
+}
+
+// confirm marks the settings applied in the current boot as stable once they
+// have been in place for after. It reports whether it did.
+func (g *bootGuard) confirm(after time.Duration) (bool, error) {
+	state, st, err := g.status()
+	if err != nil || state != bootGuardApplying {
+		return false, err
+	}
+	up, err := g.uptime()
+	if err != nil {
+		return false, fmt.Errorf("could not read the uptime: %w", err)
+	}
+	if up-st.Uptime < after {
+		return false, nil
+	}
+	return true, g.enter(bootGuardStable, st.BootID)
+}
+
+// clear forgets the state, so that the next boot applies the settings again.
+func (g *bootGuard) clear() error {
+	if err := os.Remove(g.path()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// bootGuardUnits returns the confirming service and the timer that runs it after
+// the persisted settings have been applied for stableAfter.
+func bootGuardUnits(exePath string, stableAfter time.Duration) (service, timer string) {
+	service = fmt.Sprintf(`[Unit]
+Description=Confirm that the Undervolt Go settings applied on boot are stable
+
+[Service]
+Type=oneshot
+ExecStart=%s boot-guard confirm --after %s
+`, exePath, stableAfter)
+	timer = fmt.Sprintf(`[Unit]
+Description=Confirm the Undervolt Go settings once they have been applied for %s
+
+[Timer]
+OnActiveSec=%d
+AccuracySec=1s
+`, stableAfter, int64(math.Ceil(stableAfter.Seconds())))
+	return service, timer
+}
+
+// ---------- Boot Guard Command ----------
+
+var bootGuardAfterFlag time.Duration
+
+var bootGuardCmd = &cobra.Command{
+	Use:   "boot-guard",
+	Short: "Show or clear the boot guard of the persisted settings",
+	Long: "With --persist, the persisted settings are applied on boot only when the boot that last applied them " +
+		"stayed up for --boot-guard afterwards. Otherwise the guard trips: the settings are skipped on every boot " +
+		"until the guard is cleared or persistence is enabled again.",
+}
+
+var bootGuardStatusCmd = &cobra.Command{
+	Use:         "status",
+	Annotations: map[string]string{privilegesAnnotation: privilegesNone},
+	Short:       "Show the state of the boot guard",
+	Args:        cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		state, st, err := newBootGuard().status()
+		if err != nil {
+			return err
+		}
+		switch state {
+		case "":
+			fmt.Println("No persisted settings were applied yet.")
+		case bootGuardApplying:
+			fmt.Printf("Applying: the settings were applied in this boot at %s and are not confirmed yet.\n", st.Time.Format(time.RFC3339))
+		case bootGuardStable:
+			fmt.Printf("Stable: the settings were confirmed at %s.\n", st.Time.Format(time.RFC3339))
+		case bootGuardTripped:
+			fmt.Printf("Tripped: the boot at %s applied the settings and did not stay up to confirm them; they are skipped.\n"+
+				"Run 'undervolt-go boot-guard clear' to apply them again on the next boot.\n", st.Time.Format(time.RFC3339))
+		}
+		return nil
+	},
+}
+
+var bootGuardBeginCmd = &cobra.Command{
+	Use:    "begin",
+	Short:  "Mark the persisted settings as being applied, failing when the guard tripped",
+	Hidden: true,
+	Args:   cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := newBootGuard().begin()
+		if errors.Is(err, errBootGuardTripped) {
+			fmt.Fprintln(os.Stderr, "WARNING: The persisted undervolt settings are NOT applied, as they may have locked up the machine.\n"+
+				"WARNING: Check the settings, then run 'undervolt-go boot-guard clear' or enable persistence again.")
+		}
+		return err
+	},
+}
+
+var bootGuardConfirmCmd = &cobra.Command{
+	Use:    "confirm",
+	Short:  "Mark the settings applied in this boot as stable once applied for --after",
+	Hidden: true,
+	Args:   cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		confirmed, err := newBootGuard().confirm(bootGuardAfterFlag)
+		if err != nil {
+			return err
+		}
+		if confirmed {
+			fmt.Println("The persisted settings are confirmed as stable.")
+		}
+		return nil
+	},
+}
+
+var bootGuardClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Clear the boot guard, so that the persisted settings are applied on the next boot",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := newBootGuard().clear(); err != nil {
+			return err
+		}
+		fmt.Println("Boot guard cleared.")
+		return nil
+	},
+}
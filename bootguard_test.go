@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBoot is the boot ID and uptime seen by a test boot guard.
+type fakeBoot struct {
+	id     string
+	uptime time.Duration
+}
+
+func (b *fakeBoot) reboot(id string) {
+	b.id, b.uptime = id, 10*time.Second
+}
+
+func newTestBootGuard(t *testing.T, boot *fakeBoot) *bootGuard {
+	t.Helper()
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	return &bootGuard{
+		dir:    t.TempDir(),
+		bootID: func() (string, error) { return boot.id, nil },
+		uptime: func() (time.Duration, error) { return boot.uptime, nil },
+		now:    func() time.Time { return start.Add(boot.uptime) },
+	}
+}
+
+func wantBootGuardState(t *testing.T, g *bootGuard, want string) {
+	t.Helper()
+	state, _, err := g.status()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if state != want {
+		t.Errorf("state = %q, want %q", state, want)
+	}
+}
+
+func TestBootGuardConfirm(t *testing.T) {
+	boot := &fakeBoot{}
+	boot.reboot("boot-1")
+	g := newTestBootGuard(t, boot)
+	wantBootGuardState(t, g, "")
+
+	if err := g.begin(); err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	wantBootGuardState(t, g, bootGuardApplying)
+
+	// The timer fires early, e.g. when the service took long to apply
+	boot.uptime += 4 * time.Minute
+	if ok, err := g.confirm(5 * time.Minute); ok || err != nil {
+		t.Fatalf("confirm after 4m = %v, %v; want false", ok, err)
+	}
+	wantBootGuardState(t, g, bootGuardApplying)
+
+	boot.uptime += time.Minute
+	if ok, err := g.confirm(5 * time.Minute); !ok || err != nil {
+		t.Fatalf("confirm after 5m = %v, %v; want true", ok, err)
+	}
+	wantBootGuardState(t, g, bootGuardStable)
+
+	// The next boot applies the settings again
+	boot.reboot("boot-2")
+	if err := g.begin(); err != nil {
+		t.Fatalf("begin on the next boot: %v", err)
+	}
+	wantBootGuardState(t, g, bootGuardApplying)
+}
+
+func TestBootGuardTripped(t *testing.T) {
+	boot := &fakeBoot{}
+	boot.reboot("boot-1")
+	g := newTestBootGuard(t, boot)
+	if err := g.begin(); err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+
+	// The machine locks up before confirming; the next boot must not apply
+	boot.reboot("boot-2")
+	wantBootGuardState(t, g, bootGuardTripped)
+	err := g.begin()
+	if !errors.Is(err, errBootGuardTripped) {
+		t.Fatalf("begin after lock-up: error = %v, want %v", err, errBootGuardTripped)
+	}
+	if !strings.Contains(err.Error(), "2024-05-01T08:00:10Z") {
+		t.Errorf("error %q does not name the boot that applied the settings", err)
+	}
+	if ok, err := g.confirm(0); ok || err != nil {
+		t.Errorf("confirm of a tripped guard = %v, %v; want false", ok, err)
+	}
+
+	// It stays tripped on the following boots until cleared
+	boot.reboot("boot-3")
+	if err := g.begin(); !errors.Is(err, errBootGuardTripped) {
+		t.Errorf("begin on the following boot: error = %v, want %v", err, errBootGuardTripped)
+	}
+	if err := g.clear(); err != nil {
+		t.Fatalf("clear: %v", err)
+	}
+	wantBootGuardState(t, g, "")
+	if err := g.begin(); err != nil {
+		t.Errorf("begin after clear: %v", err)
+	}
+	if err := g.clear(); err != nil {
+		t.Fatalf("clear: %v", err)
+	}
+	if err := g.clear(); err != nil {
+		t.Errorf("clear without state: %v", err)
+	}
+}
+
+func TestBootGuardResume(t *testing.T) {
+	boot := &fakeBoot{}
+	boot.reboot("boot-1")
+	g := newTestBootGuard(t, boot)
+	if err := g.begin(); err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	_, first, _ := g.status()
+
+	// The service runs again on resume, in the same boot
+	boot.uptime += 2 * time.Minute
+	if err := g.begin(); err != nil {
+		t.Fatalf("begin on resume: %v", err)
+	}
+	_, again, _ := g.status()
+	if again.Uptime != first.Uptime {
+		t.Errorf("begin on resume restarted the confirmation: uptime %v, want %v", again.Uptime, first.Uptime)
+	}
+
+	// Suspended time counts: the uptime includes it
+	boot.uptime += 3 * time.Minute
+	if ok, err := g.confirm(5 * time.Minute); !ok || err != nil {
+		t.Fatalf("confirm = %v, %v; want true", ok, err)
+	}
+	boot.uptime += time.Hour
+	if err := g.begin(); err != nil {
+		t.Fatalf("begin on a later resume: %v", err)
+	}
+	wantBootGuardState(t, g, bootGuardStable)
+}
+
+func TestBootGuardUnits(t *testing.T) {
+	service, timer := bootGuardUnits("/usr/local/bin/undervolt-go", 90500*time.Millisecond)
+	if !strings.Contains(service, "ExecStart=/usr/local/bin/undervolt-go boot-guard confirm --after 1m30.5s\n") {
+		t.Errorf("service:\n%s", service)
+	}
+	if !strings.Contains(timer, "OnActiveSec=91\n") {
+		t.Errorf("timer:\n%s", timer)
+	}
+}
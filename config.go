@@ -0,0 +1,850 @@
+// config.go
+// Typed config.yaml: named profiles, the auto-switch mapping and the recorded temperature targets.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Version of the config.yaml layout. Files without schema_version use the
+// untyped layout of earlier releases and are migrated when loaded.
+const configSchemaVersion = 2
+
+// PowerLimitSetting is one RAPL power limit term of a profile.
+type PowerLimitSetting struct {
+	PowerW float64 `json:"power_w" yaml:"power_w"`
+	TimeS  float64 `json:"time_s" yaml:"time_s"`
+}
+
+// Profile is a named set of settings. Settings that are unset (nil, or a plane
+// missing from Planes) are left untouched when the profile is applied.
+type Profile struct {
+	Planes  map[string]float64 `json:"planes,omitempty" yaml:"planes,omitempty"` // offset in mV by plane name
+	Temp    *int               `json:"temp,omitempty" yaml:"temp,omitempty"`     // °C on AC
+	TempBat *int               `json:"temp_bat,omitempty" yaml:"temp_bat,omitempty"`
+	Turbo   *bool              `json:"turbo,omitempty" yaml:"turbo,omitempty"` // true = turbo enabled
+	P1      *PowerLimitSetting `json:"p1,omitempty" yaml:"p1,omitempty"`
+	P2      *PowerLimitSetting `json:"p2,omitempty" yaml:"p2,omitempty"`
+	Lock    *bool              `json:"lock,omitempty" yaml:"lock,omitempty"`
+	Notes   []string           `json:"notes,omitempty" yaml:"notes,omitempty"` // e.g. values that could not be read by --from-live
+}
+
+// AutoSwitchConfig maps power sources to profile names.
+type AutoSwitchConfig struct {
+	AC      string `yaml:"ac,omitempty"`
+	Battery string `yaml:"battery,omitempty"`
+}
+
+// TempTargetsConfig holds the --temp/--temp-bat values of the last apply.
+type TempTargetsConfig struct {
+	AC      *int `yaml:"ac,omitempty"`
+	Battery *int `yaml:"battery,omitempty"`
+}
+
+// Config is the content of config.yaml.
+type Config struct {
+	SchemaVersion int                 `yaml:"schema_version"`
+	Profiles      map[string]*Profile `yaml:"profiles,omitempty"`
+	AutoSwitch    AutoSwitchConfig    `yaml:"auto_switch,omitempty"`
+	TempTargets   TempTargetsConfig   `yaml:"temp_targets,omitempty"`
+}
+
+func newConfig() *Config {
+	return &Config{SchemaVersion: configSchemaVersion, Profiles: map[string]*Profile{}}
+}
+
+func configPath() string {
+	return filepath.Join(configDir(), configFileName)
+}
+
+// loadConfig reads and validates config.yaml. A missing file is an empty config.
+// A file in the legacy layout is migrated and, when possible, rewritten.
+func loadConfig() (*Config, error) {
+	cfg, data, migrated, err := readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if migrated {
+		// Without write access (not root) the migrated config is only used in memory.
+		backup := configPath() + ".v1.bak"
+		if err := os.WriteFile(backup, data, 0644); err == nil {
+			if err := cfg.save(); err == nil {
+				fmt.Fprintf(os.Stderr, "Migrated %s to schema version %d (previous file saved as %s).\n",
+					configPath(), configSchemaVersion, backup)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// readConfig reads config.yaml like loadConfig, but never rewrites it: a legacy
+// file is migrated in memory only. For readers that must not write, e.g. the
+// D-Bus properties.
+func readConfig() (*Config, error) {
+	cfg, _, _, err := readConfigFile()
+	return cfg, err
+}
+
+// readConfigFile reads and parses config.yaml, returning its content and whether it was migrated.
+func readConfigFile() (*Config, []byte, bool, error) {
+	data, err := os.ReadFile(configPath())
+	if os.IsNotExist(err) {
+		return newConfig(), nil, false, nil
+	}
+	if err != nil {
+		return nil, nil, false, err
+	}
+	cfg, migrated, err := parseConfig(data)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("%s: %w", configPath(), err)
+	}
+	return cfg, data, migrated, nil
+}
+
+// parseConfig decodes config.yaml and reports whether it had to be migrated from the legacy layout.
+func parseConfig(data []byte) (*Config, bool, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, false, err
+	}
+	if len(doc.Content) == 0 {
+		return newConfig(), false, nil
+	}
+	root := doc.Content[0]
+	version := 1
+	if v := mappingValue(root, "schema_version"); v != nil {
+		var err error
+		if version, err = intNode(v, "schema_version"); err != nil {
+			return nil, false, err
+		}
+	}
+	switch {
+	case version == 1:
+		cfg, err := migrateLegacyConfig(root)
+		return cfg, true, err
+	case version > configSchemaVersion:
+		return nil, false, nodeErrorf(root, "schema_version %d is newer than this version of undervolt-go supports (%d)", version, configSchemaVersion)
+	case version != configSchemaVersion:
+		return nil, false, nodeErrorf(root, "unsupported schema_version %d", version)
+	}
+	cfg := newConfig()
+	if err := root.Decode(cfg); err != nil {
+		return nil, false, err
+	}
+	return cfg, false, nil
+}
+
+// save writes the config to config.yaml.
+func (c *Config) save() error {
+	if err := os.MkdirAll(configDir(), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(configPath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := yaml.NewEncoder(f)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// ---------- Validation ----------
+
+// The offset field of the voltage mailbox covers ±1 V.
+const maxOffsetMV = 1000
+
+func checkPlaneOffset(name string, mV float64) error {
+	if _, ok := findPlane(name); !ok {
+		return fmt.Errorf("unknown plane %q", name)
+	}
+	if math.IsNaN(mV) || math.Abs(mV) > maxOffsetMV {
+		return fmt.Errorf("%s offset %v mV out of range (-%d to %d)", name, mV, maxOffsetMV, maxOffsetMV)
+	}
+	return nil
+}
+
+func checkTempTarget(t int) error {
+	if t < 1 || t > 127 {
+		return fmt.Errorf("temperature target %d°C out of range (1 to 127)", t)
+	}
+	return nil
+}
+
+func checkPowerLimitSetting(pl PowerLimitSetting) error {
+	if !(pl.PowerW > 0) || math.IsInf(pl.PowerW, 0) {
+		return fmt.Errorf("power limit must be a positive number of watts, got %v", pl.PowerW)
+	}
+	if !(pl.TimeS > 0) || math.IsInf(pl.TimeS, 0) {
+		return fmt.Errorf("time window must be a positive number of seconds, got %v", pl.TimeS)
+	}
+	return nil
+}
+
+// nodeErrorf returns an error pointing at the line of n.
+func nodeErrorf(n *yaml.Node, format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", n.Line, fmt.Sprintf(format, args...))
+}
+
+// mappingValue returns the value of key in mapping node n, or nil.
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// forEachPair calls fn for every key/value pair of mapping node n.
+func forEachPair(n *yaml.Node, what string, fn func(key, val *yaml.Node) error) error {
+	if n.Kind != yaml.MappingNode {
+		return nodeErrorf(n, "%s must be a mapping", what)
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if err := fn(n.Content[i], n.Content[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func intNode(n *yaml.Node, what string) (int, error) {
+	if n.Kind != yaml.ScalarNode || n.ShortTag() != "!!int" {
+		return 0, nodeErrorf(n, "%s must be an integer, got %q", what, n.Value)
+	}
+	var v int
+	if err := n.Decode(&v); err != nil {
+		return 0, nodeErrorf(n, "%s: invalid integer %q", what, n.Value)
+	}
+	return v, nil
+}
+
+func floatNode(n *yaml.Node, what string) (float64, error) {
+	if n.Kind != yaml.ScalarNode || (n.ShortTag() != "!!int" && n.ShortTag() != "!!float") {
+		return 0, nodeErrorf(n, "%s must be a number, got %q", what, n.Value)
+	}
+	var v float64
+	if err := n.Decode(&v); err != nil {
+		return 0, nodeErrorf(n, "%s: invalid number %q", what, n.Value)
+	}
+	return v, nil
+}
+
+func boolNode(n *yaml.Node, what string) (bool, error) {
+	if n.Kind != yaml.ScalarNode || n.ShortTag() != "!!bool" {
+		return false, nodeErrorf(n, "%s must be true or false, got %q", what, n.Value)
+	}
+	var v bool
+	err := n.Decode(&v)
+	return v, err
+}
+
+func stringNode(n *yaml.Node, what string) (string, error) {
+	if n.Kind != yaml.ScalarNode || n.ShortTag() != "!!str" {
+		return "", nodeErrorf(n, "%s must be a string, got %q", what, n.Value)
+	}
+	return n.Value, nil
+}
+
+// UnmarshalYAML decodes config.yaml strictly, rejecting unknown keys and invalid values.
+func (c *Config) UnmarshalYAML(n *yaml.Node) error {
+	return forEachPair(n, "config", func(key, val *yaml.Node) error {
+		switch key.Value {
+		case "schema_version":
+			v, err := intNode(val, key.Value)
+			c.SchemaVersion = v
+			return err
+		case "profiles":
+			return forEachPair(val, "profiles", func(name, pn *yaml.Node) error {
+				if err := checkProfileName(name.Value); err != nil {
+					return nodeErrorf(name, "%v", err)
+				}
+				p := &Profile{}
+				if err := pn.Decode(p); err != nil {
+					return err
+				}
+				c.Profiles[name.Value] = p
+				return nil
+			})
+		case "auto_switch":
+			return forEachPair(val, "auto_switch", func(k, v *yaml.Node) error {
+				name, err := stringNode(v, "auto_switch."+k.Value)
+				if err != nil {
+					return err
+				}
+				if err := checkProfileName(name); err != nil {
+					return nodeErrorf(v, "%v", err)
+				}
+				switch k.Value {
+				case powerSourceAC:
+					c.AutoSwitch.AC = name
+				case powerSourceBattery:
+					c.AutoSwitch.Battery = name
+				default:
+					return nodeErrorf(k, "unknown power source %q (use ac or battery)", k.Value)
+				}
+				return nil
+			})
+		case "temp_targets":
+			return forEachPair(val, "temp_targets", func(k, v *yaml.Node) error {
+				t, err := intNode(v, "temp_targets."+k.Value)
+				if err != nil {
+					return err
+				}
+				switch k.Value {
+				case powerSourceAC:
+					c.TempTargets.AC = &t
+				case powerSourceBattery:
+					c.TempTargets.Battery = &t
+				default:
+					return nodeErrorf(k, "unknown power source %q (use ac or battery)", k.Value)
+				}
+				return nil
+			})
+		}
+		return nodeErrorf(key, "unknown setting %q", key.Value)
+	})
+}
+
+// UnmarshalYAML decodes a profile strictly, rejecting unknown keys and invalid values.
+func (p *Profile) UnmarshalYAML(n *yaml.Node) error {
+	return forEachPair(n, "profile", func(key, val *yaml.Node) error {
+		switch key.Value {
+		case "planes":
+			p.Planes = map[string]float64{}
+			return forEachPair(val, "planes", func(k, v *yaml.Node) error {
+				mV, err := floatNode(v, k.Value+" offset")
+				if err != nil {
+					return err
+				}
+				if err := checkPlaneOffset(k.Value, mV); err != nil {
+					return nodeErrorf(k, "%v", err)
+				}
+				p.Planes[k.Value] = mV
+				return nil
+			})
+		case "temp", "temp_bat":
+			t, err := intNode(val, key.Value)
+			if err != nil {
+				return err
+			}
+			if err := checkTempTarget(t); err != nil {
+				return nodeErrorf(val, "%v", err)
+			}
+			if key.Value == "temp" {
+				p.Temp = &t
+			} else {
+				p.TempBat = &t
+			}
+			return nil
+		case "turbo", "lock":
+			b, err := boolNode(val, key.Value)
+			if err != nil {
+				return err
+			}
+			if key.Value == "turbo" {
+				p.Turbo = &b
+			} else {
+				p.Lock = &b
+			}
+			return nil
+		case "p1", "p2":
+			var pl PowerLimitSetting
+			seen := map[string]bool{}
+			err := forEachPair(val, key.Value, func(k, v *yaml.Node) error {
+				var err error
+				switch k.Value {
+				case "power_w":
+					pl.PowerW, err = floatNode(v, key.Value+".power_w")
+				case "time_s":
+					pl.TimeS, err = floatNode(v, key.Value+".time_s")
+				default:
+					return nodeErrorf(k, "unknown %s setting %q (use power_w and time_s)", key.Value, k.Value)
+				}
+				seen[k.Value] = true
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			if !seen["power_w"] || !seen["time_s"] {
+				return nodeErrorf(val, "%s needs both power_w and time_s", key.Value)
+			}
+			if err := checkPowerLimitSetting(pl); err != nil {
+				return nodeErrorf(val, "%s: %v", key.Value, err)
+			}
+			if key.Value == "p1" {
+				p.P1 = &pl
+			} else {
+				p.P2 = &pl
+			}
+			return nil
+		case "notes":
+			if val.Kind != yaml.SequenceNode {
+				return nodeErrorf(val, "notes must be a list")
+			}
+			for _, item := range val.Content {
+				note, err := stringNode(item, "note")
+				if err != nil {
+					return err
+				}
+				p.Notes = append(p.Notes, note)
+			}
+			return nil
+		}
+		return nodeErrorf(key, "unknown profile setting %q", key.Value)
+	})
+}
+
+// ---------- Legacy Layout ----------
+
+// legacyProfile is a profile as written through viper before schema version 2.
+// Unset planes were stored as NaN, unset temperatures and turbo as -1 (or 0).
+type legacyProfile struct {
+	Planes map[string]float64 `yaml:"planes"`
+	TL     struct {
+		Temp    int `yaml:"temp"`
+		TempBat int `yaml:"temp-bat"`
+	} `yaml:"tl"`
+	Turbo *int `yaml:"turbo"`
+	PL    struct {
+		P1 []float64 `yaml:"p1"`
+		P2 []float64 `yaml:"p2"`
+	} `yaml:"pl"`
+}
+
+type legacyConfig struct {
+	Profiles    map[string]legacyProfile `yaml:"profiles"`
+	AutoSwitch  map[string]string        `yaml:"auto-switch"`
+	TempTargets map[string]int           `yaml:"temp-targets"`
+}
+
+// migrateLegacyConfig converts a config.yaml without schema_version. The old
+// 'profile save' accepted any name and values, so profiles with invalid names are
+// renamed and invalid settings dropped, with a warning, rather than failing the
+// migration (the original file is kept as config.yaml.v1.bak).
+func migrateLegacyConfig(root *yaml.Node) (*Config, error) {
+	var old legacyConfig
+	if err := root.Decode(&old); err != nil {
+		return nil, fmt.Errorf("legacy config: %w", err)
+	}
+	cfg := newConfig()
+	names := legacyProfileNames(slices.Sorted(maps.Keys(old.Profiles)))
+	for oldName, lp := range old.Profiles {
+		name := names[oldName]
+		if name != oldName {
+			fmt.Fprintf(os.Stderr, "Warning: legacy profile %q renamed to '%s': %v\n", oldName, name, checkProfileName(oldName))
+		}
+		p := &Profile{}
+		for plane, mV := range lp.Planes {
+			if math.IsNaN(mV) {
+				continue
+			}
+			if err := checkPlaneOffset(plane, mV); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: dropping %s offset of legacy profile '%s': %v\n", plane, name, err)
+				continue
+			}
+			if p.Planes == nil {
+				p.Planes = map[string]float64{}
+			}
+			p.Planes[plane] = mV
+		}
+		if t := lp.TL.Temp; t > 0 {
+			p.Temp = &t
+		}
+		if t := lp.TL.TempBat; t > 0 {
+			p.TempBat = &t
+		}
+		if lp.Turbo != nil && *lp.Turbo >= 0 {
+			enabled := *lp.Turbo == 0
+			p.Turbo = &enabled
+		}
+		for i, arr := range [][]float64{lp.PL.P1, lp.PL.P2} {
+			if len(arr) != 2 {
+				continue
+			}
+			pl := &PowerLimitSetting{PowerW: arr[0], TimeS: arr[1]}
+			if err := checkPowerLimitSetting(*pl); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: dropping P%d of legacy profile '%s': %v\n", i+1, name, err)
+				continue
+			}
+			if i == 0 {
+				p.P1 = pl
+			} else {
+				p.P2 = pl
+			}
+		}
+		cfg.Profiles[name] = p
+	}
+	renamed := func(name string) string {
+		if n, ok := names[name]; ok {
+			return n
+		}
+		return name
+	}
+	cfg.AutoSwitch.AC = renamed(old.AutoSwitch[powerSourceAC])
+	cfg.AutoSwitch.Battery = renamed(old.AutoSwitch[powerSourceBattery])
+	if t, ok := old.TempTargets[powerSourceAC]; ok && t > 0 {
+		cfg.TempTargets.AC = &t
+	}
+	if t, ok := old.TempTargets[powerSourceBattery]; ok && t > 0 {
+		cfg.TempTargets.Battery = &t
+	}
+	return cfg, nil
+}
+
+// legacyProfileNames maps the legacy profile names to valid ones. Valid names are
+// kept; the others are lowercased, invalid characters replaced by '-' and a
+// number appended where the result is reserved or already taken.
+func legacyProfileNames(names []string) map[string]string {
+	valid := make(map[string]string, len(names))
+	taken := map[string]bool{}
+	for _, name := range names {
+		if checkProfileName(name) == nil {
+			valid[name] = name
+			taken[name] = true
+		}
+	}
+	invalidChars := regexp.MustCompile(`[^a-z0-9_-]+`)
+	for _, name := range names {
+		if _, ok := valid[name]; ok {
+			continue
+		}
+		base := strings.TrimLeft(invalidChars.ReplaceAllString(strings.ToLower(name), "-"), "-_")
+		if base == "" {
+			base = "profile"
+		}
+		candidate := base
+		for n := 2; taken[candidate] || checkProfileName(candidate) != nil; n++ {
+			candidate = fmt.Sprintf("%s-%d", base, n)
+		}
+		valid[name] = candidate
+		taken[candidate] = true
+	}
+	return valid
+}
+
+// ---------- Named Profiles ----------
+
+// Profile names are used as command arguments and as YAML keys.
+var profileNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// "auto" selects a profile by power source in 'profile apply auto', "live" is the
+// hardware state in 'profile diff'.
+var reservedProfileNames = []string{"auto", liveProfileName}
+
+// checkProfileName checks that name can be stored as a profile.
+func checkProfileName(name string) error {
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q (use lowercase letters, digits, '-' and '_')", name)
+	}
+	if slices.Contains(reservedProfileNames, name) {
+		return fmt.Errorf("'%s' is reserved and cannot be used as a profile name", name)
+	}
+	return nil
+}
+
+// profile returns the named profile or an error if it does not exist.
+func (c *Config) profile(name string) (*Profile, error) {
+	p, ok := c.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile '%s' not found", name)
+	}
+	return p, nil
+}
+
+// profileNames returns the saved profiles in alphabetical order
+func (c *Config) profileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// removeProfile deletes a profile and any auto-switch mapping to it.
+func (c *Config) removeProfile(name string) {
+	delete(c.Profiles, name)
+	if c.AutoSwitch.AC == name {
+		c.AutoSwitch.AC = ""
+	}
+	if c.AutoSwitch.Battery == name {
+		c.AutoSwitch.Battery = ""
+	}
+}
+
+// copyProfile copies profile src to a new profile dst. It refuses to overwrite an existing profile.
+func (c *Config) copyProfile(src, dst string) error {
+	p, err := c.profile(src)
+	if err != nil {
+		return err
+	}
+	if err := checkProfileName(dst); err != nil {
+		return err
+	}
+	if _, ok := c.Profiles[dst]; ok {
+		return fmt.Errorf("profile '%s' already exists", dst)
+	}
+	cp := *p
+	cp.Planes = maps.Clone(p.Planes)
+	c.Profiles[dst] = &cp
+	return nil
+}
+
+// autoSwitchProfile returns the profile that auto-switch applies on the given power source.
+// Without a configured mapping the profile named after the power source is used.
+func (c *Config) autoSwitchProfile(source string) string {
+	name := c.AutoSwitch.AC
+	if source == powerSourceBattery {
+		name = c.AutoSwitch.Battery
+	}
+	if name == "" {
+		return source
+	}
+	return name
+}
+
+// setAutoSwitchProfile maps a power source to a profile.
+func (c *Config) setAutoSwitchProfile(source, name string) {
+	if source == powerSourceBattery {
+		c.AutoSwitch.Battery = name
+	} else {
+		c.AutoSwitch.AC = name
+	}
+}
+
+// autoSwitchSources returns the power sources that are mapped to profile name.
+func (c *Config) autoSwitchSources(name string) []string {
+	var sources []string
+	for _, source := range []string{powerSourceAC, powerSourceBattery} {
+		if c.autoSwitchProfile(source) == name {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// ---------- Profiles and Flags ----------
+
+// profileFromFlags builds a profile from the command line flags. Flags that were not given stay unset.
+func profileFromFlags() (*Profile, error) {
+	p := &Profile{}
+	for i, plane := range planes {
+		if math.IsNaN(planeOffsets[i]) {
+			continue
+		}
+		if err := checkPlaneOffset(plane.Name, planeOffsets[i]); err != nil {
+			return nil, err
+		}
+		if p.Planes == nil {
+			p.Planes = map[string]float64{}
+		}
+		p.Planes[plane.Name] = planeOffsets[i]
+	}
+	for _, t := range []struct {
+		flag int
+		dst  **int
+	}{{tempFlag, &p.Temp}, {tempBatFlag, &p.TempBat}} {
+		if t.flag <= 0 {
+			continue
+		}
+		if err := checkTempTarget(t.flag); err != nil {
+			return nil, err
+		}
+		v := t.flag
+		*t.dst = &v
+	}
+	if turboFlag >= 0 {
+		enabled := turboFlag == 0
+		p.Turbo = &enabled
+	}
+	var err error
+	if p.P1, err = powerLimitFromArgs("P1", p1Args); err != nil {
+		return nil, err
+	}
+	if p.P2, err = powerLimitFromArgs("P2", p2Args); err != nil {
+		return nil, err
+	}
+	if lockPowerLimit {
+		lock := true
+		p.Lock = &lock
+	}
+	return p, nil
+}
+
+// powerLimitFromArgs parses the two values of --p1/--p2; nil if the flag was not given.
+func powerLimitFromArgs(term string, args []string) (*PowerLimitSetting, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	if len(args) != 2 {
+		return nil, fmt.Errorf("%s requires two arguments: POWER_LIMIT TIME_WINDOW", term)
+	}
+	power, err1 := strToFloat64(args[0])
+	timeWin, err2 := strToFloat64(args[1])
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("invalid numeric args for %s", term)
+	}
+	pl := &PowerLimitSetting{PowerW: power, TimeS: timeWin}
+	if err := checkPowerLimitSetting(*pl); err != nil {
+		return nil, fmt.Errorf("%s: %w", term, err)
+	}
+	return pl, nil
+}
+
+// setFlags loads the settings of p into the flag variables used by applyFlags.
+// Settings the profile leaves unset keep their command line values.
+func (p *Profile) setFlags() {
+	for i, plane := range planes {
+		if mV, ok := p.Planes[plane.Name]; ok {
+			planeOffsets[i] = mV
+		}
+	}
+	if p.Temp != nil {
+		tempFlag = *p.Temp
+	}
+	if p.TempBat != nil {
+		tempBatFlag = *p.TempBat
+	}
+	if p.Turbo != nil {
+		turboFlag = 1
+		if *p.Turbo {
+			turboFlag = 0
+		}
+	}
+	if p.P1 != nil {
+		p1Args = []string{formatFloat(p.P1.PowerW), formatFloat(p.P1.TimeS)}
+	}
+	if p.P2 != nil {
+		p2Args = []string{formatFloat(p.P2.PowerW), formatFloat(p.P2.TimeS)}
+	}
+	if p.Lock != nil {
+		lockPowerLimit = *p.Lock
+	}
+}
+
+// args returns the command line flags that apply the settings of p.
+func (p *Profile) args() []string {
+	var args []string
+	for _, plane := range planes {
+		if mV, ok := p.Planes[plane.Name]; ok {
+			args = append(args, "--"+plane.Name+"="+formatFloat(mV))
+		}
+	}
+	if p.Temp != nil {
+		args = append(args, "--temp="+strconv.Itoa(*p.Temp))
+	}
+	if p.TempBat != nil {
+		args = append(args, "--temp-bat="+strconv.Itoa(*p.TempBat))
+	}
+	if p.Turbo != nil && *p.Turbo {
+		args = append(args, "--turbo=0")
+	} else if p.Turbo != nil {
+		args = append(args, "--turbo=1")
+	}
+	if p.P1 != nil {
+		args = append(args, "--p1="+formatFloat(p.P1.PowerW)+","+formatFloat(p.P1.TimeS))
+	}
+	if p.P2 != nil {
+		args = append(args, "--p2="+formatFloat(p.P2.PowerW)+","+formatFloat(p.P2.TimeS))
+	}
+	if p.Lock != nil && *p.Lock {
+		args = append(args, "--lock-power-limit")
+	}
+	return args
+}
+
+// validate checks the values of a profile that did not come through the
+// strict YAML decoding, e.g. one received over the control socket.
+func (p *Profile) validate() error {
+	for name, mV := range p.Planes {
+		if err := checkPlaneOffset(name, mV); err != nil {
+			return err
+		}
+	}
+	for _, t := range []*int{p.Temp, p.TempBat} {
+		if t == nil {
+			continue
+		}
+		if err := checkTempTarget(*t); err != nil {
+			return err
+		}
+	}
+	for _, pl := range []struct {
+		term string
+		pl   *PowerLimitSetting
+	}{{"P1", p.P1}, {"P2", p.P2}} {
+		if pl.pl == nil {
+			continue
+		}
+		if err := checkPowerLimitSetting(*pl.pl); err != nil {
+			return fmt.Errorf("%s: %w", pl.term, err)
+		}
+	}
+	return nil
+}
+
+// formatFloat prints f without trailing zeros.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// ---------- Profile Output ----------
+
+// writeProfileText prints the settings of p, "-" for unset ones.
+func writeProfileText(w io.Writer, p *Profile) {
+	fmt.Fprintln(w, "Voltage Offsets:")
+	for _, plane := range planes {
+		if mV, ok := p.Planes[plane.Name]; ok {
+			fmt.Fprintf(w, "   %s: %.2f mV\n", plane.Name, mV)
+		} else {
+			fmt.Fprintf(w, "   %s: -\n", plane.Name)
+		}
+	}
+	fmt.Fprintf(w, "Temperature target: AC: %s / Battery: %s\n", formatTemp(p.Temp), formatTemp(p.TempBat))
+	turbo := "-"
+	if p.Turbo != nil {
+		turbo = boolToEnabled(*p.Turbo)
+	}
+	fmt.Fprintf(w, "Intel Turbo: %s\n", turbo)
+	for _, t := range []struct {
+		name string
+		pl   *PowerLimitSetting
+	}{{"P1", p.P1}, {"P2", p.P2}} {
+		if t.pl == nil {
+			fmt.Fprintf(w, "Power limit %s: -\n", t.name)
+			continue
+		}
+		fmt.Fprintf(w, "Power limit %s: %sW, %ss\n", t.name, formatFloat(t.pl.PowerW), formatFloat(t.pl.TimeS))
+	}
+	if p.Lock != nil && *p.Lock {
+		fmt.Fprintln(w, "Power limit lock: yes")
+	}
+	for _, note := range p.Notes {
+		fmt.Fprintf(w, "Note: %s\n", note)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMigrateLegacyProfileNames(t *testing.T) {
+	legacy := `profiles:
+  Gaming Mode:
+    planes: {core: -80, cache: .nan}
+  gaming-mode:
+    planes: {core: -60}
+  auto:
+    planes: {core: -50}
+  quiet:
+    planes: {core: -70, gpu: -5000}
+    pl: {p1: [15, 28]}
+auto-switch:
+  ac: Gaming Mode
+  battery: quiet
+`
+	cfg, migrated, err := parseConfig([]byte(legacy))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	if !migrated {
+		t.Error("legacy config not reported as migrated")
+	}
+	want := map[string]float64{"gaming-mode": -60, "gaming-mode-2": -80, "auto-2": -50, "quiet": -70}
+	if len(cfg.Profiles) != len(want) {
+		t.Errorf("profiles = %v, want %v", cfg.profileNames(), want)
+	}
+	for name, core := range want {
+		p, err := cfg.profile(name)
+		if err != nil {
+			t.Errorf("%v", err)
+			continue
+		}
+		if p.Planes["core"] != core {
+			t.Errorf("profile '%s' core = %v, want %v", name, p.Planes["core"], core)
+		}
+	}
+	if q := cfg.Profiles["quiet"]; q != nil {
+		if _, ok := q.Planes["gpu"]; ok {
+			t.Error("invalid gpu offset of 'quiet' was kept")
+		}
+		if q.P1 == nil || q.P1.PowerW != 15 {
+			t.Errorf("P1 of 'quiet' = %+v, want 15 W", q.P1)
+		}
+	}
+	if cfg.AutoSwitch.AC != "gaming-mode-2" || cfg.AutoSwitch.Battery != "quiet" {
+		t.Errorf("auto-switch = %+v, want the renamed profiles", cfg.AutoSwitch)
+	}
+}
@@ -0,0 +1,271 @@
+// daemon.go
+// Long-running daemon that holds the desired settings and reapplies them when the hardware drifts.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// settingsDaemon holds the desired settings and corrects the hardware when it drifts from them.
+type settingsDaemon struct {
+	dev         MSRDevice
+	msr         MSR
+	out         io.Writer
+	powerSource func() string // currentPowerSource, replaced in tests
+
+	// target is the profile to hold, "auto" for the one mapped to the power
+	// source, or "" for the settings given on the command line (flagsProfile).
+	target       string
+	flagsProfile *Profile
+	cfg          *Config
+
+	source  string   // power source at the last check
+	name    string   // description of the settings held, for messages
+	desired *Profile // nil when there is nothing to hold
+	// Last problems reported, so that a persisting problem is reported once.
+	lastNote, lastUnread string
+	// Drift left by the last failed correction; it is not retried until the
+	// drift changes, so locked or firmware-held registers are not rewritten
+	// on every check.
+	failedDrift string
+}
+
+// clearSettingFlags resets the flag variables that applyFlags applies to "unset",
+// so that nothing of previously applied settings carries over.
+func clearSettingFlags() {
+	for i := range planeOffsets {
+		planeOffsets[i] = math.NaN()
+	}
+	tempFlag, tempBatFlag, turboFlag = -1, -1, -1
+	p1Args, p2Args = nil, nil
+	lockPowerLimit = false
+}
+
+// note prints a problem, unless it is the one printed last.
+func (d *settingsDaemon) note(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if msg != d.lastNote {
+		fmt.Fprintln(d.out, msg)
+		d.lastNote = msg
+	}
+}
+
+// resolve picks the settings to hold on the current power source.
+func (d *settingsDaemon) resolve() {
+	d.failedDrift = ""
+	switch d.target {
+	case "":
+		d.name, d.desired = "command line settings", d.flagsProfile
+		return
+	case "auto":
+		d.name = d.cfg.autoSwitchProfile(d.source)
+	default:
+		d.name = d.target
+	}
+	p, err := d.cfg.profile(d.name)
+	if err != nil {
+		d.note("%v; nothing to hold on %s power", err, d.source)
+		d.desired = nil
+		return
+	}
+	d.name, d.desired = fmt.Sprintf("profile '%s'", d.name), p
+}
+
+// drift returns the differences between the desired and the live settings, in
+// the form the hardware stores them. Settings that cannot be read are skipped.
+func (d *settingsDaemon) drift() []ProfileDifference {
+	live, unread := readLiveProfile(d.dev, d.msr)
+	var skip []string
+	var problems []string
+	for _, u := range unread {
+		skip = append(skip, u.Fields...)
+		problems = append(problems, u.String())
+	}
+	if msg := strings.Join(problems, "; "); msg != d.lastUnread {
+		if msg != "" {
+			fmt.Fprintf(d.out, "Could not read (not verified): %s\n", msg)
+		}
+		d.lastUnread = msg
+	}
+	return diffProfiles(d.desired.asApplied(d.dev, d.msr, d.source), live, true, skip)
+}
+
+func formatDrift(diffs []ProfileDifference) string {
+	var parts []string
+	for _, diff := range diffs {
+		parts = append(parts, fmt.Sprintf("%s %s (want %s)", diff.Field, formatFieldValue(diff.B), formatFieldValue(diff.A)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// check verifies the hardware against the desired settings and reapplies them on drift.
+func (d *settingsDaemon) check(reason string) {
+	if source := d.powerSource(); source != d.source {
+		if d.source != "" {
+			fmt.Fprintf(d.out, "Power source changed to %s.\n", source)
+		}
+		d.source = source
+		d.resolve()
+	}
+	if d.desired == nil {
+		return
+	}
+	diffs := d.drift()
+	if len(diffs) == 0 || formatDrift(diffs) == d.failedDrift {
+		return
+	}
+	fmt.Fprintf(d.out, "Drift detected (%s): %s. Reapplying %s.\n", reason, formatDrift(diffs), d.name)
+	clearSettingFlags()
+	d.desired.setFlags()
+	// The settings are already recorded; a correction is no change of the user
+	if err := writeFlagSettings(d.dev, d.msr, d.source); err != nil {
+		d.note("Reapplying %s failed: %v", d.name, err)
+		d.failedDrift = formatDrift(d.drift())
+		return
+	}
+	if diffs := d.drift(); len(diffs) > 0 {
+		d.note("Still differs after reapplying %s: %s", d.name, formatDrift(diffs))
+		d.failedDrift = formatDrift(diffs)
+		return
+	}
+	d.lastNote, d.failedDrift = "", ""
+	fmt.Fprintf(d.out, "Corrected.\n")
+}
+
+// reload rereads the profiles, e.g. after they were edited.
+func (d *settingsDaemon) reload() {
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(d.out, "Could not reload the config, keeping the previous one: %v\n", err)
+		return
+	}
+	d.cfg = cfg
+	d.resolve()
+	fmt.Fprintf(d.out, "Config reloaded; holding %s.\n", d.name)
+}
+
+// listenPowerSupplyEvents signals on events whenever the kernel reports a
+// power_supply uevent (AC plugged or unplugged, battery state changes).
+func listenPowerSupplyEvents(events chan<- struct{}) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return err
+	}
+	// Group 1 receives the uevents of the kernel.
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1}); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 16384)
+		for {
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err != nil {
+				if err == syscall.EINTR {
+					continue
+				}
+				return
+			}
+			// The message is a NUL separated list: "change@/devices/...", "ACTION=change", ...
+			if bytes.Contains(buf[:n], []byte("\x00SUBSYSTEM=power_supply\x00")) {
+				select {
+				case events <- struct{}{}:
+				default: // a check is pending already
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// ---------- Daemon Command ----------
+
+var daemonIntervalFlag time.Duration
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon [<profile>|auto]",
+	Short: "Keep settings applied, reapplying them when the firmware resets them",
+	Long: "Hold the given profile, the profile mapped to the current power source ('auto'), or the settings " +
+		"given as flags, and verify every --interval and on every power supply change that the hardware still " +
+		"has them. Settings the firmware reset (after suspend, on dock/undock, on power state changes) are " +
+		"reapplied, and every correction is logged to standard output. A correction that fails is not retried " +
+		"until the drift changes. SIGHUP rereads the profiles.",
+	Example: "  undervolt-go daemon auto\n  undervolt-go daemon --core -80 --cache -80 --p1 35,28",
+	Args:    cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if daemonIntervalFlag < time.Second {
+			return fmt.Errorf("--interval must be at least 1s, got %v", daemonIntervalFlag)
+		}
+		setupLogging()
+		d := &settingsDaemon{dev: openMSRDevice(), msr: ADDRESSES, out: os.Stdout, powerSource: currentPowerSource}
+		if len(args) == 1 {
+			if flagsModifyHardware() {
+				return fmt.Errorf("give either a profile or settings flags, not both")
+			}
+			d.target = strings.ToLower(args[0])
+		} else if flagsModifyHardware() {
+			p, err := profileFromFlags()
+			if err != nil {
+				return err
+			}
+			d.flagsProfile = p
+		} else {
+			d.target = "auto"
+		}
+		if d.target != "" {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			d.cfg = cfg
+			if d.target != "auto" {
+				if _, err := cfg.profile(d.target); err != nil {
+					return err
+				}
+			}
+		}
+
+		events := make(chan struct{}, 1)
+		if err := listenPowerSupplyEvents(events); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot listen for power supply events (%v); power source changes are noticed within %v.\n",
+				err, daemonIntervalFlag)
+		}
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		defer signal.Stop(signals)
+		ticker := time.NewTicker(daemonIntervalFlag)
+		defer ticker.Stop()
+
+		d.check("start")
+		if d.desired != nil {
+			fmt.Fprintf(d.out, "Holding %s on %s power, checking every %v.\n", d.name, d.source, daemonIntervalFlag)
+		}
+		for {
+			select {
+			case sig := <-signals:
+				if sig == syscall.SIGHUP {
+					d.reload()
+					d.check("reload")
+					continue
+				}
+				return nil
+			case <-events:
+				d.check("power supply change")
+			case <-ticker.C:
+				d.check("periodic check")
+			}
+		}
+	},
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+// droppingMSR ignores writes to one register, like firmware that overrides it.
+type droppingMSR struct {
+	*simMSR
+	addr uint64
+}
+
+func (d droppingMSR) Write(cpu int, addr uint64, val uint64) error {
+	if addr == d.addr {
+		return nil
+	}
+	return d.simMSR.Write(cpu, addr, val)
+}
+
+// testDaemon returns a daemon holding the auto-switch profiles "ac" (core -80 mV,
+// P1 35 W) and "battery" (core -50 mV, 80 °C) on sim, with the power source
+// read from *source.
+func testDaemon(t *testing.T, dev MSRDevice, source *string) (*settingsDaemon, *bytes.Buffer) {
+	t.Helper()
+	withFlags(t, func() {})
+	temp := 80
+	cfg := newConfig()
+	cfg.Profiles["ac"] = &Profile{Planes: map[string]float64{"core": -80}, P1: &PowerLimitSetting{PowerW: 35, TimeS: 28}}
+	cfg.Profiles["battery"] = &Profile{Planes: map[string]float64{"core": -50}, Temp: &temp}
+	var out bytes.Buffer
+	d := &settingsDaemon{
+		dev: dev, msr: ADDRESSES, out: &out,
+		powerSource: func() string { return *source },
+		target:      "auto",
+		cfg:         cfg,
+	}
+	return d, &out
+}
+
+func checkCoreOffset(t *testing.T, dev MSRDevice, want float64) {
+	t.Helper()
+	got, err := readOffset(dev, "core", ADDRESSES)
+	if err != nil {
+		t.Fatalf("readOffset: %v", err)
+	}
+	if math.Abs(got-want) > 1 {
+		t.Errorf("core offset = %.2f mV, want %.2f mV", got, want)
+	}
+}
+
+func TestDaemonCheck(t *testing.T) {
+	dev := newSimMSR(2)
+	source := powerSourceAC
+	d, out := testDaemon(t, dev, &source)
+
+	// The stock values differ from the profile
+	d.check("start")
+	if !strings.Contains(out.String(), "Reapplying profile 'ac'") || !strings.HasSuffix(out.String(), "Corrected.\n") {
+		t.Errorf("start output: %q", out.String())
+	}
+	checkCoreOffset(t, dev, -80)
+
+	out.Reset()
+	d.check("periodic check")
+	if out.Len() != 0 {
+		t.Errorf("check without drift printed %q", out.String())
+	}
+
+	// The firmware resets the offset, e.g. on resume
+	if err := setOffset(dev, "core", 0, ADDRESSES, false); err != nil {
+		t.Fatal(err)
+	}
+	d.check("periodic check")
+	if !strings.Contains(out.String(), "Drift detected (periodic check): planes.core") || !strings.HasSuffix(out.String(), "Corrected.\n") {
+		t.Errorf("drift output: %q", out.String())
+	}
+	checkCoreOffset(t, dev, -80)
+
+	out.Reset()
+	source = powerSourceBattery
+	d.check("power supply change")
+	if !strings.HasPrefix(out.String(), "Power source changed to battery.\n") || !strings.Contains(out.String(), "Reapplying profile 'battery'") {
+		t.Errorf("power source change output: %q", out.String())
+	}
+	checkCoreOffset(t, dev, -50)
+	if target, err := readTemperature(dev, ADDRESSES); err != nil || target.Target() != 80 {
+		t.Errorf("temperature target = %d°C (%v), want 80°C", target.Target(), err)
+	}
+}
+
+func TestDaemonCheckFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		dev    func() MSRDevice
+		want   string
+	}{
+		{
+			name:   "reapply fails",
+			source: powerSourceAC,
+			dev: func() MSRDevice {
+				dev := newSimMSR(1)
+				dev.regs[ADDRESSES.addrPowerLimits] |= 1 << 63
+				return dev
+			},
+			want: "Reapplying profile 'ac' failed: cannot write power limit because it is locked",
+		},
+		{
+			// The temperature target is not read back when written
+			name:   "still differs",
+			source: powerSourceBattery,
+			dev:    func() MSRDevice { return droppingMSR{newSimMSR(1), ADDRESSES.addrTemp} },
+			want:   "Still differs after reapplying profile 'battery': temp",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, out := testDaemon(t, tt.dev(), &tt.source)
+			d.check("start")
+			if !strings.Contains(out.String(), tt.want) {
+				t.Fatalf("output %q, want %q", out.String(), tt.want)
+			}
+			// A persisting problem is reported once and not retried
+			out.Reset()
+			d.check("periodic check")
+			if out.Len() != 0 {
+				t.Errorf("second check printed %q", out.String())
+			}
+		})
+	}
+}
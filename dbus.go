@@ -0,0 +1,444 @@
+// dbus.go
+// D-Bus system service exposing the settings as properties and the CLI operations as methods, guarded by polkit.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+	"github.com/spf13/cobra"
+)
+
+const (
+	dbusName      = "com.softorage.UndervoltGo"
+	dbusPath      = dbus.ObjectPath("/com/softorage/UndervoltGo")
+	dbusInterface = "com.softorage.UndervoltGo"
+
+	dbusErrorNotAuthorized = dbusInterface + ".Error.NotAuthorized"
+
+	// The files the properties depend on are checked this often, so that changes made
+	// through the CLI are signalled too. The hardware is only read again when one of
+	// them changed, on power supply events and on resume: reading the offsets writes
+	// mailbox commands to MSR 0x150.
+	dbusRefreshInterval = 5 * time.Second
+)
+
+// dbusPowerLimit is the D-Bus form (bdd) of a package power limit.
+type dbusPowerLimit struct {
+	Enabled bool
+	PowerW  float64
+	TimeS   float64
+}
+
+// dbusArgNames names the arguments of the methods in the introspection data.
+var dbusArgNames = map[string][]string{
+	"ApplyProfile":         {"name", "force"},
+	"SetOffsets":           {"offsets", "force"},
+	"SetPowerLimit":        {"term", "power_w", "time_s"},
+	"LockPowerLimit":       {},
+	"SetTemperatureTarget": {"ac", "battery"},
+	"SetTurbo":             {"enabled"},
+	"EnablePersistence":    {"profile", "force"},
+	"DisablePersistence":   {},
+}
+
+// dbusService is exported on dbusPath. Its exported methods are the D-Bus methods.
+type dbusService struct {
+	mu    sync.Mutex // calls go through the global flag variables
+	dev   MSRDevice
+	props *prop.Properties
+	// authorize returns an error when sender may not perform the polkit action.
+	authorize func(sender dbus.Sender, action string) *dbus.Error
+	// senderUID returns the uid of the process that sent a call.
+	senderUID func(sender dbus.Sender) (uint32, error)
+	stamp     string // dbusChangeStamp at the last refresh
+}
+
+// dbusWatchedPaths are the files changed along with the settings: the config (profiles,
+// auto-switch), the history (every change made with the CLI) and the persistence service.
+func dbusWatchedPaths() []string {
+	return []string{configPath(), historyDir(), persistConfigServicePath}
+}
+
+// dbusChangeStamp summarizes the modification times of dbusWatchedPaths.
+func dbusChangeStamp() string {
+	var b strings.Builder
+	for _, path := range dbusWatchedPaths() {
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(&b, "%d/%d;", info.ModTime().UnixNano(), info.Size())
+		} else {
+			b.WriteString("-;")
+		}
+	}
+	return b.String()
+}
+
+// busSenderUID asks the bus for the uid of the sender.
+func busSenderUID(conn *dbus.Conn) func(dbus.Sender) (uint32, error) {
+	return func(sender dbus.Sender) (uint32, error) {
+		var uid uint32
+		err := conn.BusObject().Call("org.freedesktop.DBus.GetConnectionUnixUser", 0, string(sender)).Store(&uid)
+		return uid, err
+	}
+}
+
+// polkitAuthorizer asks polkit, letting it prompt the user when the action requires authentication.
+func polkitAuthorizer(conn *dbus.Conn) func(dbus.Sender, string) *dbus.Error {
+	authority := conn.Object("org.freedesktop.PolicyKit1", "/org/freedesktop/PolicyKit1/Authority")
+	return func(sender dbus.Sender, action string) *dbus.Error {
+		subject := struct {
+			Kind    string
+			Details map[string]dbus.Variant
+		}{"system-bus-name", map[string]dbus.Variant{"name": dbus.MakeVariant(string(sender))}}
+		var result struct {
+			Authorized, Challenge bool
+			Details               map[string]string
+		}
+		const allowUserInteraction = uint32(1)
+		err := authority.Call("org.freedesktop.PolicyKit1.Authority.CheckAuthorization", 0,
+			subject, action, map[string]string{}, allowUserInteraction, "").Store(&result)
+		if err != nil {
+			return dbus.MakeFailedError(fmt.Errorf("could not check the authorization with polkit: %w", err))
+		}
+		if !result.Authorized {
+			return dbus.NewError(dbusErrorNotAuthorized, []any{"not authorized for " + action})
+		}
+		return nil
+	}
+}
+
+// call authorizes sender for the actions, runs fn, recording changes for the
+// sender, and updates the properties.
+func (s *dbusService) call(sender dbus.Sender, method string, actions []string, fn func() error) *dbus.Error {
+	for _, action := range actions {
+		if err := s.authorize(sender, action); err != nil {
+			return err
+		}
+	}
+	origin := &changeOrigin{User: string(sender), Command: "dbus: " + method}
+	if uid, err := s.senderUID(sender); err == nil {
+		origin.User = uidUser(uid)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requestOrigin = origin
+	defer func() { requestOrigin = nil }()
+	err := fn()
+	s.refresh()
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+// applyActions returns the actions needed to apply settings, with force when positive offsets are allowed.
+func applyActions(force bool) []string {
+	actions := []string{polkitActionApply}
+	if force {
+		actions = append(actions, polkitActionForce)
+	}
+	return actions
+}
+
+// ApplyProfile applies a saved profile, or with "auto" the one mapped to the power source.
+func (s *dbusService) ApplyProfile(sender dbus.Sender, name string, force bool) *dbus.Error {
+	return s.call(sender, "ApplyProfile", applyActions(force), func() error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		name := strings.ToLower(name)
+		if name == "auto" {
+			name = cfg.autoSwitchProfile(currentPowerSource())
+		}
+		p, err := cfg.profile(name)
+		if err != nil {
+			return err
+		}
+		return applyProfile(s.dev, p, force)
+	})
+}
+
+// SetOffsets sets the voltage offsets in mV, by plane name.
+func (s *dbusService) SetOffsets(sender dbus.Sender, offsets map[string]float64, force bool) *dbus.Error {
+	return s.call(sender, "SetOffsets", applyActions(force), func() error {
+		p := &Profile{Planes: offsets}
+		if err := p.validate(); err != nil {
+			return err
+		}
+		return applyProfile(s.dev, p, force)
+	})
+}
+
+// SetPowerLimit sets the "p1" or "p2" package power limit.
+func (s *dbusService) SetPowerLimit(sender dbus.Sender, term string, powerW, timeS float64) *dbus.Error {
+	return s.call(sender, "SetPowerLimit", applyActions(false), func() error {
+		pl := &PowerLimitSetting{PowerW: powerW, TimeS: timeS}
+		p := &Profile{}
+		switch strings.ToLower(term) {
+		case "p1":
+			p.P1 = pl
+		case "p2":
+			p.P2 = pl
+		default:
+			return fmt.Errorf("unknown power limit %q (use p1 or p2)", term)
+		}
+		if err := p.validate(); err != nil {
+			return err
+		}
+		return applyProfile(s.dev, p, false)
+	})
+}
+
+// LockPowerLimit locks the power limits until the next reset.
+func (s *dbusService) LockPowerLimit(sender dbus.Sender) *dbus.Error {
+	return s.call(sender, "LockPowerLimit", applyActions(false), func() error {
+		lock := true
+		return applyProfile(s.dev, &Profile{Lock: &lock}, false)
+	})
+}
+
+// SetTemperatureTarget sets the temperature targets in °C on AC and on battery; 0 leaves one unset.
+func (s *dbusService) SetTemperatureTarget(sender dbus.Sender, ac, battery int32) *dbus.Error {
+	return s.call(sender, "SetTemperatureTarget", applyActions(false), func() error {
+		p := &Profile{}
+		if ac != 0 {
+			t := int(ac)
+			p.Temp = &t
+		}
+		if battery != 0 {
+			t := int(battery)
+			p.TempBat = &t
+		}
+		if err := p.validate(); err != nil {
+			return err
+		}
+		return applyProfile(s.dev, p, false)
+	})
+}
+
+// SetTurbo enables or disables Intel Turbo Boost.
+func (s *dbusService) SetTurbo(sender dbus.Sender, enabled bool) *dbus.Error {
+	return s.call(sender, "SetTurbo", applyActions(false), func() error {
+		return applyProfile(s.dev, &Profile{Turbo: &enabled}, false)
+	})
+}
+
+// EnablePersistence applies a saved profile on every boot and resume.
+func (s *dbusService) EnablePersistence(sender dbus.Sender, profile string, force bool) *dbus.Error {
+	actions := []string{polkitActionPersist}
+	if force {
+		actions = append(actions, polkitActionForce)
+	}
+	return s.call(sender, "EnablePersistence", actions, func() error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		p, err := cfg.profile(strings.ToLower(profile))
+		if err != nil {
+			return err
+		}
+		args := p.args()
+		if len(args) == 0 {
+			return fmt.Errorf("profile '%s' has no settings to persist", profile)
+		}
+		if force {
+			args = append(args, "--force")
+		}
+		return enablePersistence(args)
+	})
+}
+
+// DisablePersistence removes the boot and resume service.
+func (s *dbusService) DisablePersistence(sender dbus.Sender) *dbus.Error {
+	return s.call(sender, "DisablePersistence", []string{polkitActionPersist}, disablePersistence)
+}
+
+// dbusProperties returns the current values of the properties. Values that
+// cannot be read are reported as zero.
+func (s *dbusService) dbusProperties() map[string]any {
+	source := currentPowerSource()
+	live, unread := readLiveProfile(s.dev, ADDRESSES)
+	powerLimit := func(pl *PowerLimitSetting) dbusPowerLimit {
+		if pl == nil {
+			return dbusPowerLimit{}
+		}
+		return dbusPowerLimit{true, pl.PowerW, pl.TimeS}
+	}
+	target := live.Temp
+	if target == nil {
+		target = live.TempBat
+	}
+	var skip []string
+	for _, u := range unread {
+		skip = append(skip, u.Fields...)
+	}
+	profiles, active := []string{}, ""
+	if cfg, err := readConfig(); err == nil {
+		profiles = cfg.profileNames()
+		active = matchingProfile(cfg, s.dev, ADDRESSES, live, skip, source)
+	}
+	return map[string]any{
+		"Offsets":           live.Planes,
+		"PowerLimit1":       powerLimit(live.P1),
+		"PowerLimit2":       powerLimit(live.P2),
+		"PowerLimitLocked":  live.Lock != nil && *live.Lock,
+		"TemperatureTarget": int32(intOrZero(target)),
+		"TurboEnabled":      live.Turbo != nil && *live.Turbo,
+		"PowerSource":       source,
+		"Persistent":        readPersistenceStatus().Enabled,
+		"Profiles":          profiles,
+		"ActiveProfile":     active,
+	}
+}
+
+// refresh rereads the properties and signals those that changed.
+func (s *dbusService) refresh() {
+	s.stamp = dbusChangeStamp()
+	for name, v := range s.dbusProperties() {
+		if !reflect.DeepEqual(s.props.GetMust(dbusInterface, name), v) {
+			s.props.SetMust(dbusInterface, name, v)
+		}
+	}
+}
+
+// exportDBusService exports the service on conn and takes the bus name.
+func exportDBusService(conn *dbus.Conn, s *dbusService) error {
+	props := map[string]*prop.Prop{}
+	s.stamp = dbusChangeStamp()
+	for name, v := range s.dbusProperties() {
+		props[name] = &prop.Prop{Value: v, Emit: prop.EmitTrue}
+	}
+	var err error
+	if s.props, err = prop.Export(conn, dbusPath, prop.Map{dbusInterface: props}); err != nil {
+		return err
+	}
+	if err := conn.Export(s, dbusPath, dbusInterface); err != nil {
+		return err
+	}
+
+	methods := introspect.Methods(s)
+	for i, m := range methods {
+		names := dbusArgNames[m.Name]
+		for j := range m.Args {
+			if j < len(names) {
+				methods[i].Args[j].Name = names[j]
+			}
+		}
+	}
+	node := &introspect.Node{
+		Name: string(dbusPath),
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			prop.IntrospectData,
+			{Name: dbusInterface, Methods: methods, Properties: s.props.Introspection(dbusInterface)},
+		},
+	}
+	if err := conn.Export(introspect.NewIntrospectable(node), dbusPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		return err
+	}
+
+	reply, err := conn.RequestName(dbusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return fmt.Errorf("%s is already owned by another process", dbusName)
+	}
+	return nil
+}
+
+// ---------- D-Bus Command ----------
+
+var dbusSessionFlag bool
+
+var dbusCmd = &cobra.Command{
+	Use:   "dbus",
+	Short: "Run the D-Bus system service for desktop applets and scripts",
+	Long: "Own " + dbusName + " on the system bus and serve " + string(dbusPath) + ": the live offsets, power " +
+		"limits, temperature target, turbo state, power source, persistence, saved profiles and the profile " +
+		"matching the live settings as properties (with PropertiesChanged signals), and methods to apply " +
+		"profiles, set offsets, power limits, temperature targets and turbo, and manage persistence. Every " +
+		"method is authorized with polkit. With --session the service runs on the session bus without polkit, " +
+		"for testing together with --simulate.",
+	Example: "  busctl introspect " + dbusName + " " + string(dbusPath) + "\n" +
+		"  busctl call -- " + dbusName + " " + string(dbusPath) + " " + dbusInterface + " SetOffsets a{sd}b 2 core -80 cache -80 false",
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		setupLogging()
+		s := &dbusService{dev: openMSRDevice()}
+		var conn *dbus.Conn
+		var err error
+		if dbusSessionFlag {
+			conn, err = dbus.ConnectSessionBus()
+			s.authorize = func(dbus.Sender, string) *dbus.Error { return nil } // the session bus is private to the user
+		} else {
+			conn, err = dbus.ConnectSystemBus()
+			if err == nil {
+				s.authorize = polkitAuthorizer(conn)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("could not connect to the bus: %w", err)
+		}
+		defer conn.Close()
+		s.senderUID = busSenderUID(conn)
+		if err := exportDBusService(conn, s); err != nil {
+			return fmt.Errorf("could not export the D-Bus service: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Serving %s on %s\n", dbusName, string(dbusPath))
+
+		// Firmware may reset the settings on power supply changes and on resume
+		events := make(chan struct{}, 1)
+		if err := listenPowerSupplyEvents(events); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot listen for power supply events: %v\n", err)
+		}
+		signals := make(chan *dbus.Signal, 8)
+		conn.Signal(signals)
+		if err := conn.AddMatchSignal(dbus.WithMatchInterface("org.freedesktop.login1.Manager"),
+			dbus.WithMatchMember("PrepareForSleep")); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot listen for resume: %v\n", err)
+		}
+
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(stop)
+		ticker := time.NewTicker(dbusRefreshInterval)
+		defer ticker.Stop()
+		refresh := func() {
+			s.mu.Lock()
+			s.refresh()
+			s.mu.Unlock()
+		}
+		for {
+			select {
+			case <-stop:
+				return nil
+			case <-events:
+				refresh()
+			case sig := <-signals:
+				// PrepareForSleep(false) is sent on resume
+				if sig.Name == "org.freedesktop.login1.Manager.PrepareForSleep" && len(sig.Body) == 1 && sig.Body[0] == false {
+					refresh()
+				}
+			case <-ticker.C:
+				s.mu.Lock()
+				if dbusChangeStamp() != s.stamp {
+					s.refresh()
+				}
+				s.mu.Unlock()
+			}
+		}
+	},
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"math"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// countingMSR counts the register writes.
+type countingMSR struct {
+	*simMSR
+	writes atomic.Int32
+}
+
+func (c *countingMSR) Write(cpu int, addr uint64, val uint64) error {
+	c.writes.Add(1)
+	return c.simMSR.Write(cpu, addr, val)
+}
+
+// startPrivateBus runs a dbus-daemon for the test and returns its address.
+func startPrivateBus(t *testing.T) string {
+	t.Helper()
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not installed")
+	}
+	cmd := exec.Command(daemon, "--session", "--address=unix:dir="+t.TempDir(), "--nofork", "--print-address=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	addr, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("dbus-daemon address: %v", err)
+	}
+	return strings.TrimSpace(addr)
+}
+
+func TestDBusService(t *testing.T) {
+	addr := startPrivateBus(t)
+	withFlags(t, func() {})
+
+	server, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer server.Close()
+	dev := &countingMSR{simMSR: newSimMSR(2)}
+	s := &dbusService{
+		dev: dev,
+		authorize: func(_ dbus.Sender, action string) *dbus.Error {
+			if action == polkitActionForce {
+				return dbus.NewError(dbusErrorNotAuthorized, []any{"not authorized for " + action})
+			}
+			return nil
+		},
+		senderUID: busSenderUID(server),
+	}
+	if err := exportDBusService(server, s); err != nil {
+		t.Fatalf("exportDBusService: %v", err)
+	}
+
+	client, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer client.Close()
+	obj := client.Object(dbusName, dbusPath)
+
+	// Property reads are served from the cache, without mailbox commands
+	writes := dev.writes.Load()
+	for range 3 {
+		if _, err := obj.GetProperty(dbusInterface + ".Offsets"); err != nil {
+			t.Fatalf("Get Offsets: %v", err)
+		}
+	}
+	if n := dev.writes.Load() - writes; n != 0 {
+		t.Errorf("reading a property wrote %d MSRs", n)
+	}
+
+	changed := make(chan *dbus.Signal, 8)
+	client.Signal(changed)
+	if err := client.AddMatchSignal(dbus.WithMatchObjectPath(dbusPath),
+		dbus.WithMatchInterface("org.freedesktop.DBus.Properties"), dbus.WithMatchMember("PropertiesChanged")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := obj.Call(dbusInterface+".SetOffsets", 0, map[string]float64{"core": -80}, false).Err; err != nil {
+		t.Fatalf("SetOffsets: %v", err)
+	}
+	timeout := time.After(5 * time.Second)
+	for offsets := map[string]float64(nil); offsets == nil; {
+		select {
+		case sig := <-changed:
+			if v, ok := sig.Body[1].(map[string]dbus.Variant)["Offsets"]; ok {
+				offsets, _ = v.Value().(map[string]float64)
+				if math.Abs(offsets["core"]+80) > 1 {
+					t.Errorf("PropertiesChanged Offsets = %v, want core -80", offsets)
+				}
+			}
+		case <-timeout:
+			t.Fatal("no PropertiesChanged of Offsets after SetOffsets")
+		}
+	}
+
+	err = obj.Call(dbusInterface+".SetOffsets", 0, map[string]float64{"core": 20}, true).Err
+	if dbusErr, ok := err.(dbus.Error); !ok || dbusErr.Name != dbusErrorNotAuthorized {
+		t.Errorf("SetOffsets with force: error = %v, want %s", err, dbusErrorNotAuthorized)
+	}
+	if err := obj.Call(dbusInterface+".SetPowerLimit", 0, "p3", 35.0, 28.0).Err; err == nil {
+		t.Error("SetPowerLimit p3 succeeded")
+	}
+}
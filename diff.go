@@ -0,0 +1,304 @@
+// diff.go
+// Reading the live settings as a profile and comparing profiles field by field.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// "live" stands for the current hardware state in 'profile diff'.
+const liveProfileName = "live"
+
+// liveReadError is a setting that could not be read from hardware.
+type liveReadError struct {
+	Setting string   // shown to the user
+	Fields  []string // the flattened profile fields it covers
+	Err     error
+}
+
+func (e liveReadError) String() string {
+	return fmt.Sprintf("%s: %v", e.Setting, e.Err)
+}
+
+// readLiveProfile reads the settings currently active in hardware as a profile.
+// The temperature target is stored for the current power source only.
+// Values that could not be read are left unset and returned as errors.
+func readLiveProfile(dev MSRDevice, msr MSR) (*Profile, []liveReadError) {
+	p := &Profile{Planes: map[string]float64{}}
+	var unread []liveReadError
+	for _, plane := range planes {
+		mV, err := readOffset(dev, plane.Name, msr)
+		if err != nil {
+			unread = append(unread, liveReadError{plane.Name + " offset", []string{"planes." + plane.Name}, err})
+			continue
+		}
+		p.Planes[plane.Name] = mV
+	}
+	if temp, err := readTemperature(dev, msr); err != nil {
+		unread = append(unread, liveReadError{"temperature target", []string{"temp", "temp_bat"}, err})
+	} else if t := temp.Target(); currentPowerSource() == powerSourceBattery {
+		p.TempBat = &t
+	} else {
+		p.Temp = &t
+	}
+	if noTurbo, err := readTurbo(); err != nil {
+		unread = append(unread, liveReadError{"turbo", []string{"turbo"}, err})
+	} else {
+		enabled := noTurbo == 0
+		p.Turbo = &enabled
+	}
+	if pl, err := readPowerLimit(dev, msr); err != nil {
+		unread = append(unread, liveReadError{"power limits",
+			[]string{"p1.power_w", "p1.time_s", "p2.power_w", "p2.time_s", "lock"}, err})
+	} else {
+		if pl.LongTermEnabled {
+			p.P1 = &PowerLimitSetting{PowerW: pl.LongTermPower, TimeS: pl.LongTermTime}
+		}
+		if pl.ShortTermEnabled {
+			p.P2 = &PowerLimitSetting{PowerW: pl.ShortTermPower, TimeS: pl.ShortTermTime}
+		}
+		p.Lock = &pl.Locked
+	}
+	return p, unread
+}
+
+// asApplied returns p as the hardware would report it after applying it on the
+// given power source: offsets and power limits rounded to what the registers can
+// hold, and only the temperature target of that power source.
+func (p *Profile) asApplied(dev MSRDevice, msr MSR, source string) *Profile {
+	q := *p
+	q.Planes = make(map[string]float64, len(p.Planes))
+	for name, mV := range p.Planes {
+		q.Planes[name] = unconvertOffset(convertOffset(mV))
+	}
+	q.Temp, q.TempBat = nil, nil
+	if t := resolveTempTarget(intOrZero(p.Temp), intOrZero(p.TempBat), source); t > 0 {
+		if source == powerSourceBattery {
+			q.TempBat = &t
+		} else {
+			q.Temp = &t
+		}
+	}
+	if units, err := readMSR(dev, msr.addrUnits, 0); err == nil {
+		powerUnit := math.Pow(2, float64(units&0xf))
+		timeUnit := math.Pow(2, float64((units>>16)&0xf))
+		quantize := func(pl *PowerLimitSetting) *PowerLimitSetting {
+			if pl == nil {
+				return nil
+			}
+			return &PowerLimitSetting{
+				PowerW: float64(int(pl.PowerW*powerUnit)) / powerUnit,
+				TimeS:  toSeconds(fromSeconds(pl.TimeS, timeUnit), timeUnit),
+			}
+		}
+		q.P1, q.P2 = quantize(p.P1), quantize(p.P2)
+	}
+	return &q
+}
+
+func intOrZero(v *int) int {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+// profileField is one setting of a flattened profile; Value is nil when unset.
+type profileField struct {
+	Name  string
+	Value any
+}
+
+// fields flattens p into a fixed list of settings.
+func (p *Profile) fields() []profileField {
+	var fields []profileField
+	for _, plane := range planes {
+		var v any
+		if mV, ok := p.Planes[plane.Name]; ok {
+			v = mV
+		}
+		fields = append(fields, profileField{"planes." + plane.Name, v})
+	}
+	optInt := func(v *int) any {
+		if v == nil {
+			return nil
+		}
+		return *v
+	}
+	optBool := func(v *bool) any {
+		if v == nil {
+			return nil
+		}
+		return *v
+	}
+	fields = append(fields,
+		profileField{"temp", optInt(p.Temp)},
+		profileField{"temp_bat", optInt(p.TempBat)},
+		profileField{"turbo", optBool(p.Turbo)},
+	)
+	for _, t := range []struct {
+		name string
+		pl   *PowerLimitSetting
+	}{{"p1", p.P1}, {"p2", p.P2}} {
+		var power, window any
+		if t.pl != nil {
+			power, window = t.pl.PowerW, t.pl.TimeS
+		}
+		fields = append(fields, profileField{t.name + ".power_w", power}, profileField{t.name + ".time_s", window})
+	}
+	return append(fields, profileField{"lock", optBool(p.Lock)})
+}
+
+// ProfileDifference is a setting that differs between two profiles; nil values are unset.
+type ProfileDifference struct {
+	Field string `json:"field" yaml:"field"`
+	A     any    `json:"a" yaml:"a"`
+	B     any    `json:"b" yaml:"b"`
+}
+
+// ProfileDiff is the result of 'profile diff'.
+type ProfileDiff struct {
+	A           string              `json:"a" yaml:"a"`
+	B           string              `json:"b" yaml:"b"`
+	Differences []ProfileDifference `json:"differences" yaml:"differences"`
+	Unreadable  []string            `json:"unreadable,omitempty" yaml:"unreadable,omitempty"`
+}
+
+// diffProfiles compares a and b field by field, leaving out the skipped fields.
+// With onlySetInA, settings that a leaves unset are skipped as well, as they do
+// not constrain b (used against the live state).
+func diffProfiles(a, b *Profile, onlySetInA bool, skip []string) []ProfileDifference {
+	diffs := []ProfileDifference{}
+	bFields := b.fields()
+	for i, fa := range a.fields() {
+		fb := bFields[i]
+		if (onlySetInA && fa.Value == nil) || slices.Contains(skip, fa.Name) {
+			continue
+		}
+		if !fieldValuesEqual(fa.Value, fb.Value) {
+			diffs = append(diffs, ProfileDifference{fa.Name, fa.Value, fb.Value})
+		}
+	}
+	return diffs
+}
+
+func fieldValuesEqual(a, b any) bool {
+	fa, okA := a.(float64)
+	fb, okB := b.(float64)
+	if okA && okB {
+		return math.Abs(fa-fb) < 1e-6
+	}
+	return a == b
+}
+
+// formatFieldValue prints a flattened setting, "-" when unset.
+func formatFieldValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "-"
+	case float64:
+		return formatFloat(math.Round(v*100) / 100)
+	}
+	return fmt.Sprint(v)
+}
+
+// writeProfileDiffText prints d as a table of differing settings.
+func writeProfileDiffText(w io.Writer, d ProfileDiff) {
+	if len(d.Differences) == 0 {
+		fmt.Fprintf(w, "No differences between '%s' and '%s'.\n", d.A, d.B)
+	} else {
+		tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+		fmt.Fprintf(tw, "Field\t%s\t%s\n", d.A, d.B)
+		for _, diff := range d.Differences {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", diff.Field, formatFieldValue(diff.A), formatFieldValue(diff.B))
+		}
+		tw.Flush()
+	}
+	if len(d.Unreadable) > 0 {
+		fmt.Fprintf(w, "\nCould not read (not compared):\n   %s\n", strings.Join(d.Unreadable, "\n   "))
+	}
+}
+
+// liveProfileForSave reads the live settings as a profile to be saved. Offsets are
+// rounded to 0.01 mV, which still selects the same register value, and the settings
+// that could not be read are recorded in the profile notes.
+func liveProfileForSave(dev MSRDevice, msr MSR) *Profile {
+	p, unread := readLiveProfile(dev, msr)
+	for name, mV := range p.Planes {
+		p.Planes[name] = math.Round(mV*100) / 100
+	}
+	// An unlocked register is the default; only a lock is worth restoring.
+	if p.Lock != nil && !*p.Lock {
+		p.Lock = nil
+	}
+	for _, u := range unread {
+		p.Notes = append(p.Notes, "not read from hardware: "+u.String())
+	}
+	return p
+}
+
+// ---------- Profile Diff Command ----------
+
+var profileDiffCmd = &cobra.Command{
+	Use:         "diff <a> [<b>|live]",
+	Annotations: map[string]string{privilegesAnnotation: privilegesNone},
+	Short:       "Compare two profiles, or a profile with the settings active in hardware",
+	Long: "Compare two saved profiles field by field, or, when the second argument is 'live' or omitted, " +
+		"compare a profile with the values currently read from the hardware. Against live values only the " +
+		"settings stored in the profile are compared, rounded as the registers store them, and the temperature " +
+		"target of the current power source is used.",
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateOutputFormat(outputFlag); err != nil {
+			return err
+		}
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		aName, bName := strings.ToLower(args[0]), liveProfileName
+		if len(args) == 2 {
+			bName = strings.ToLower(args[1])
+		}
+		a, err := cfg.profile(aName)
+		if err != nil {
+			return err
+		}
+
+		d := ProfileDiff{A: aName, B: bName}
+		if bName == liveProfileName {
+			setupLogging()
+			dev := openMSRDevice()
+			msr := ADDRESSES
+			live, unread := readLiveProfile(dev, msr)
+			// Settings that could not be read are reported as such, not as differences.
+			var skip []string
+			for _, u := range unread {
+				skip = append(skip, u.Fields...)
+				d.Unreadable = append(d.Unreadable, u.String())
+			}
+			d.Differences = diffProfiles(a.asApplied(dev, msr, currentPowerSource()), live, true, skip)
+		} else {
+			b, err := cfg.profile(bName)
+			if err != nil {
+				return err
+			}
+			d.Differences = diffProfiles(a, b, false, nil)
+		}
+
+		if outputFlag != outputText {
+			return encodeOutput(os.Stdout, d, outputFlag)
+		}
+		writeProfileDiffText(os.Stdout, d)
+		return nil
+	},
+}
@@ -48,13 +48,17 @@ INSTALL_PATH="/usr/local/bin/undervolt-go"
 
 # New paths created by the Go program
 PERSIST_SERVICE="/etc/systemd/system/undervolt-go.service"
+BOOT_GUARD_SERVICE="/etc/systemd/system/undervolt-go-boot-guard.service"
+BOOT_GUARD_TIMER="/etc/systemd/system/undervolt-go-boot-guard.timer"
+STATE_DIR="/var/lib/undervolt-go"
+PERSIST_UDEV="/etc/udev/rules.d/99-undervolt-go-persist.rules"
 AUTO_SERVICE="/etc/systemd/system/undervolt-go-auto.service"
 AUTO_UDEV="/etc/udev/rules.d/99-undervolt-go-auto.rules"
 CONFIG_DIR="/etc/undervolt-go"
 
 # Stop systemd services if they are running, as the binaries are being removed.
 echo "Stopping systemd services..."
-systemctl stop undervolt-go.service undervolt-go-auto.service 2>/dev/null || true
+systemctl stop undervolt-go.service undervolt-go-auto.service undervolt-go-boot-guard.timer 2>/dev/null || true
 
 # Remove binary
 if [[ -f "${INSTALL_PATH}" ]]; then
@@ -69,6 +73,18 @@ if [[ "$KEEP_PERSIST" = false ]]; then
     echo "Removing persistence service at ${PERSIST_SERVICE}..."
     rm -f "${PERSIST_SERVICE}"
   fi
+  if [[ -f "${BOOT_GUARD_TIMER}" ]]; then
+    echo "Removing boot guard timer at ${BOOT_GUARD_TIMER}..."
+    rm -f "${BOOT_GUARD_TIMER}" "${BOOT_GUARD_SERVICE}"
+  fi
+  if [[ -f "${PERSIST_UDEV}" ]]; then
+    echo "Removing udev rule at ${PERSIST_UDEV}..."
+    rm -f "${PERSIST_UDEV}"
+  fi
+  if [[ -d "${STATE_DIR}" ]]; then
+    echo "Removing boot guard state at ${STATE_DIR}..."
+    rm -rf "${STATE_DIR}"
+  fi
 else
   echo "Keeping persistence service at ${PERSIST_SERVICE}..."
 fi
@@ -1,6 +1,6 @@
 #!/bin/bash
 # install-undervolt.sh
-# Installs undervolt-go and sets up desktop launcher with pkexec
+# Installs undervolt-go and sets up the desktop launcher and the PolicyKit actions
 
 set -e
 
@@ -17,44 +17,32 @@ INSTALL_PATH="/usr/local/bin/undervolt-go-pro"
 cp undervolt-go-pro "${INSTALL_PATH}"
 chmod +x "${INSTALL_PATH}"
 
-# Install wrapper
-WRAPPER_PATH="/usr/bin/undervolt-go-wrapper"
-echo "Creating pkexec wrapper at ${WRAPPER_PATH}..."
-cat <<EOF > "${WRAPPER_PATH}"
-#!/bin/bash
-# Determine the user who initiated the pkexec action
-if [ -n "\$PKEXEC_UID" ]; then
-  USER=\$(id -un "\$PKEXEC_UID")
-elif [ -n "\$SUDO_USER" ]; then
-  USER="\$SUDO_USER"
-else
-  echo "Error: Could not determine the user. PKEXEC_UID and SUDO_USER are unset." >&2
-  exit 1
-fi
-# Check if the user was found
-if [ -z "\$USER" ]; then
-  echo "Error: Could not determine the user." >&2
-  exit 1
-fi
-# Get the user's home directory
-USER_HOME=\$(eval echo "~\${USER}")
-# Set up the display environment
-if [ -z "\$DISPLAY" ]; then
-  export DISPLAY=:0  # Fallback to :0 if DISPLAY is not set
-fi
-# Set up Xauthority
-if [ -f "\${USER_HOME}/.Xauthority" ]; then
-  export XAUTHORITY="\${USER_HOME}/.Xauthority"
-else
-  echo "Warning: .Xauthority file not found for user \${USER}." >&2
-fi
-# Debugging: Log environment variables
-echo "Launching undervolt-go-pro with DISPLAY=\$DISPLAY, XAUTHORITY=\$XAUTHORITY, USER=\$USER" >&2
-# Execute the binary
-exec ${INSTALL_PATH}
+# Install the pkexec helpers, one per PolicyKit action
+HELPER_DIR="/usr/libexec/undervolt-go"
+echo "Creating pkexec helpers in ${HELPER_DIR}..."
+mkdir -p "${HELPER_DIR}"
+for ACTION in read apply persist force-positive; do
+  cat <<EOF > "${HELPER_DIR}/${ACTION}"
+#!/bin/sh
+# Run by pkexec once PolicyKit granted com.softorage.undervolt-go.${ACTION}; arguments are ignored
+exec ${INSTALL_PATH} polkit-helper ${ACTION}
 EOF
+  chmod 755 "${HELPER_DIR}/${ACTION}"
+done
 
-chmod +x "${WRAPPER_PATH}"
+# Install the control service the GUI talks to; members of its group use it without authenticating
+SERVE_GROUP="undervolt-go"
+SERVE_SERVICE="/etc/systemd/system/undervolt-go-serve.service"
+echo "Installing control service at ${SERVE_SERVICE}..."
+getent group "${SERVE_GROUP}" >/dev/null || groupadd --system "${SERVE_GROUP}"
+sed "s#^ExecStart=.*#ExecStart=${INSTALL_PATH} serve#" undervolt-go-serve.service > "${SERVE_SERVICE}"
+chmod 644 "${SERVE_SERVICE}"
+systemctl daemon-reload
+systemctl enable --now undervolt-go-serve.service
+if [[ -n "${SUDO_USER}" ]]; then
+  usermod -aG "${SERVE_GROUP}" "${SUDO_USER}"
+  echo "Added ${SUDO_USER} to the ${SERVE_GROUP} group; log in again to change settings without authenticating."
+fi
 
 # Install icon (optional: replace with your own icon)
 ICON_PATH="/usr/share/pixmaps/undervolt-go.png"
@@ -66,31 +54,12 @@ else
 fi
 
 # Install PolicyKit file
-POLKIT_FILE="/usr/share/polkit-1/actions/com.softorage.undervolt-go.policy"
-echo "Creating PolicyKit policy at ${POLKIT_FILE}..."
-cat <<EOF > "${POLKIT_FILE}"
-<?xml version="1.0" encoding="UTF-8"?>
-<policyconfig>
-  <vendor>Softorage</vendor>
-  <vendor_url>https://softorage.com</vendor_url>
-  <action id="com.softorage.undervolt-go">
-    <description>Run 'Undervolt Go' as root</description>
-    <message>Authentication is required to run 'Undervolt Go'</message>
-    <icon_name>utilities-system-monitor</icon_name>
-    <defaults>
-      <allow_any>auth_admin</allow_any>
-      <allow_inactive>auth_admin</allow_inactive>
-      <allow_active>auth_admin</allow_active>
-    </defaults>
-    <annotate key="org.freedesktop.policykit.exec.path">/usr/bin/undervolt-go-wrapper</annotate>
-    <annotate key="org.freedesktop.policykit.exec.allow_gui">true</annotate>
-    <annotate key="org.freedesktop.policykit.exec.environment">DISPLAY XAUTHORITY</annotate>
-  </action>
-</policyconfig>
-EOF
+POLKIT_FILE="/usr/share/polkit-1/actions/com.softorage.UndervoltGo.policy"
+echo "Installing PolicyKit policy at ${POLKIT_FILE}..."
+cp com.softorage.UndervoltGo.policy "${POLKIT_FILE}"
 
 # set file permissions, allowing the owner read and write access, while group and others have only read access
-chmod 644 /usr/share/polkit-1/actions/com.softorage.undervolt-go.policy
+chmod 644 "${POLKIT_FILE}"
 
 # Install desktop file
 DESKTOP_FILE="/usr/share/applications/undervolt-go.desktop"
@@ -99,7 +68,7 @@ cat <<EOF > "${DESKTOP_FILE}"
 [Desktop Entry]
 Name=Undervolt Go
 Comment=Undervolt and tweak CPU power settings to reduce temperatures and improve performance
-Exec=pkexec ${WRAPPER_PATH}
+Exec=${INSTALL_PATH}
 Icon=${ICON_PATH}
 Terminal=false
 Type=Application
@@ -43,21 +43,28 @@ echo "Uninstalling Undervolt Go Pro..."
 
 # Define paths
 INSTALL_PATH="/usr/local/bin/undervolt-go-pro"
-WRAPPER_PATH="/usr/bin/undervolt-go-wrapper"
+OLD_WRAPPER_PATH="/usr/bin/undervolt-go-wrapper"
+HELPER_DIR="/usr/libexec/undervolt-go"
+SERVE_SERVICE="/etc/systemd/system/undervolt-go-serve.service"
 OLD_ICON_PATH="/usr/share/icons/undervolt-go.png"
 ICON_PATH="/usr/share/pixmaps/undervolt-go.png"
-POLKIT_FILE="/usr/share/polkit-1/actions/com.softorage.undervolt-go.policy"
+OLD_POLKIT_FILE="/usr/share/polkit-1/actions/com.softorage.undervolt-go.policy"
+POLKIT_FILE="/usr/share/polkit-1/actions/com.softorage.UndervoltGo.policy"
 DESKTOP_FILE="/usr/share/applications/undervolt-go.desktop"
 
 # Paths created by the Go program
 PERSIST_SERVICE="/etc/systemd/system/undervolt-go.service"
+BOOT_GUARD_SERVICE="/etc/systemd/system/undervolt-go-boot-guard.service"
+BOOT_GUARD_TIMER="/etc/systemd/system/undervolt-go-boot-guard.timer"
+STATE_DIR="/var/lib/undervolt-go"
+PERSIST_UDEV="/etc/udev/rules.d/99-undervolt-go-persist.rules"
 AUTO_SERVICE="/etc/systemd/system/undervolt-go-auto.service"
 AUTO_UDEV="/etc/udev/rules.d/99-undervolt-go-auto.rules"
 CONFIG_DIR="/etc/undervolt-go"
 
 # Stop systemd services if they are running, as the binaries are being removed.
 echo "Stopping systemd services..."
-systemctl stop undervolt-go.service undervolt-go-auto.service 2>/dev/null || true
+systemctl stop undervolt-go.service undervolt-go-auto.service undervolt-go-boot-guard.timer 2>/dev/null || true
 
 # Remove binary
 if [[ -f "${INSTALL_PATH}" ]]; then
@@ -67,10 +74,22 @@ else
   echo "No installation found at ${INSTALL_PATH}."
 fi
 
-# Remove wrapper
-if [[ -f "${WRAPPER_PATH}" ]]; then
-  echo "Removing wrapper at ${WRAPPER_PATH}..."
-  rm -f "${WRAPPER_PATH}"
+# Remove the control service
+systemctl disable --now undervolt-go-serve.service 2>/dev/null || true
+if [[ -f "${SERVE_SERVICE}" ]]; then
+  echo "Removing control service at ${SERVE_SERVICE}..."
+  rm -f "${SERVE_SERVICE}"
+fi
+
+# Remove pkexec helpers
+if [[ -d "${HELPER_DIR}" ]]; then
+  echo "Removing pkexec helpers in ${HELPER_DIR}..."
+  rm -rf "${HELPER_DIR}"
+fi
+
+# Remove the wrapper of older versions, which ran the whole GUI as root
+if [[ -f "${OLD_WRAPPER_PATH}" ]]; then
+  rm -f "${OLD_WRAPPER_PATH}"
 fi
 
 # Remove icon
@@ -90,6 +109,11 @@ if [[ -f "${POLKIT_FILE}" ]]; then
   rm -f "${POLKIT_FILE}"
 fi
 
+# Remove PolicyKit file of older versions
+if [[ -f "${OLD_POLKIT_FILE}" ]]; then
+  rm -f "${OLD_POLKIT_FILE}"
+fi
+
 # Remove desktop entry
 if [[ -f "${DESKTOP_FILE}" ]]; then
   echo "Removing desktop entry at ${DESKTOP_FILE}..."
@@ -103,6 +127,18 @@ if [[ "$KEEP_PERSIST" = false ]]; then
     echo "Removing persistence service at ${PERSIST_SERVICE}..."
     rm -f "${PERSIST_SERVICE}"
   fi
+  if [[ -f "${BOOT_GUARD_TIMER}" ]]; then
+    echo "Removing boot guard timer at ${BOOT_GUARD_TIMER}..."
+    rm -f "${BOOT_GUARD_TIMER}" "${BOOT_GUARD_SERVICE}"
+  fi
+  if [[ -f "${PERSIST_UDEV}" ]]; then
+    echo "Removing udev rule at ${PERSIST_UDEV}..."
+    rm -f "${PERSIST_UDEV}"
+  fi
+  if [[ -d "${STATE_DIR}" ]]; then
+    echo "Removing boot guard state at ${STATE_DIR}..."
+    rm -rf "${STATE_DIR}"
+  fi
 else
   echo "Keeping persistence service at ${PERSIST_SERVICE}..."
 fi
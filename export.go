@@ -0,0 +1,211 @@
+// export.go
+// Standalone profile files for sharing tunings between machines with the same CPU.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const cpuinfoPath = "/proc/cpuinfo"
+
+// CPUInfo identifies the processor a profile was tuned on.
+type CPUInfo struct {
+	Model     string `yaml:"model"`
+	Microcode string `yaml:"microcode,omitempty"`
+}
+
+// readCPUInfo returns the model name and microcode revision of the first CPU in /proc/cpuinfo.
+func readCPUInfo() (CPUInfo, error) {
+	var info CPUInfo
+	data, err := os.ReadFile(cpuinfoPath)
+	if err != nil {
+		return info, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			if info.Model != "" {
+				break // end of the first CPU
+			}
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "model name":
+			info.Model = strings.TrimSpace(value)
+		case "microcode":
+			info.Microcode = strings.TrimSpace(value)
+		}
+	}
+	if info.Model == "" {
+		return info, fmt.Errorf("no model name in %s", cpuinfoPath)
+	}
+	return info, nil
+}
+
+// ProfileExport is a profile file written by 'profile export'.
+type ProfileExport struct {
+	SchemaVersion int      `yaml:"schema_version"`
+	Name          string   `yaml:"name"`
+	CPU           CPUInfo  `yaml:"cpu"`
+	Profile       *Profile `yaml:"profile"`
+}
+
+// UnmarshalYAML decodes an exported profile strictly, rejecting unknown keys and invalid values.
+func (e *ProfileExport) UnmarshalYAML(n *yaml.Node) error {
+	if err := forEachPair(n, "profile file", func(key, val *yaml.Node) error {
+		var err error
+		switch key.Value {
+		case "schema_version":
+			if e.SchemaVersion, err = intNode(val, key.Value); err != nil {
+				return err
+			}
+			if e.SchemaVersion != configSchemaVersion {
+				return nodeErrorf(val, "unsupported schema_version %d (this version of undervolt-go reads %d)", e.SchemaVersion, configSchemaVersion)
+			}
+		case "name":
+			e.Name, err = stringNode(val, key.Value)
+		case "cpu":
+			err = forEachPair(val, "cpu", func(k, v *yaml.Node) error {
+				var err error
+				switch k.Value {
+				case "model":
+					e.CPU.Model, err = stringNode(v, "cpu.model")
+				case "microcode":
+					e.CPU.Microcode, err = stringNode(v, "cpu.microcode")
+				default:
+					return nodeErrorf(k, "unknown cpu setting %q", k.Value)
+				}
+				return err
+			})
+		case "profile":
+			e.Profile = &Profile{}
+			err = val.Decode(e.Profile)
+		default:
+			return nodeErrorf(key, "unknown setting %q", key.Value)
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+	if e.SchemaVersion == 0 {
+		return nodeErrorf(n, "missing schema_version")
+	}
+	if e.Profile == nil {
+		return nodeErrorf(n, "missing profile")
+	}
+	return nil
+}
+
+// ---------- Export/Import Commands ----------
+
+var exportFileFlag string
+
+var profileExportCmd = &cobra.Command{
+	Use:         "export <name>",
+	Annotations: map[string]string{privilegesAnnotation: privilegesNone},
+	Short:       "Write a profile to a standalone file, together with the CPU it was tuned on",
+	Args:        cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		name := strings.ToLower(args[0])
+		p, err := cfg.profile(name)
+		if err != nil {
+			return err
+		}
+		cpu, err := readCPUInfo()
+		if err != nil {
+			return fmt.Errorf("could not identify the CPU: %w", err)
+		}
+
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(ProfileExport{configSchemaVersion, name, cpu, p}); err != nil {
+			return err
+		}
+		if err := enc.Close(); err != nil {
+			return err
+		}
+		if exportFileFlag == "" || exportFileFlag == "-" {
+			_, err := os.Stdout.Write(buf.Bytes())
+			return err
+		}
+		if err := os.WriteFile(exportFileFlag, buf.Bytes(), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Profile '%s' exported to %s.\n", name, exportFileFlag)
+		return nil
+	},
+}
+
+var importAsFlag string
+var importOtherCPUFlag, importReplaceFlag bool
+
+var profileImportCmd = &cobra.Command{
+	Use:         "import <file>",
+	Annotations: map[string]string{privilegesAnnotation: privilegesRoot},
+	Short:       "Add a profile from a file written by 'profile export'",
+	Long: "Add a profile from a file written by 'profile export'.\n\n" +
+		"The profile keeps its exported name unless --as is given. Importing a profile tuned on a different CPU model " +
+		"requires --allow-other-cpu, and replacing an existing profile --replace. A different microcode revision only " +
+		"prints a warning.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		var exp ProfileExport
+		if err := yaml.Unmarshal(data, &exp); err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+
+		name := strings.ToLower(exp.Name)
+		if importAsFlag != "" {
+			name = strings.ToLower(importAsFlag)
+		}
+		if err := checkProfileName(name); err != nil {
+			return fmt.Errorf("%w; choose another name with --as", err)
+		}
+
+		cpu, err := readCPUInfo()
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Warning: could not identify this CPU (%v); cannot check that the profile matches it.\n", err)
+		case exp.CPU.Model != cpu.Model:
+			if !importOtherCPUFlag {
+				return fmt.Errorf("profile was tuned on %q but this CPU is %q; use --allow-other-cpu to import it anyway", exp.CPU.Model, cpu.Model)
+			}
+			fmt.Fprintf(os.Stderr, "Warning: profile was tuned on %q but this CPU is %q.\n", exp.CPU.Model, cpu.Model)
+		case exp.CPU.Microcode != "" && cpu.Microcode != "" && exp.CPU.Microcode != cpu.Microcode:
+			fmt.Fprintf(os.Stderr, "Warning: profile was tuned with microcode %s, this CPU runs %s. Check that it is still stable.\n",
+				exp.CPU.Microcode, cpu.Microcode)
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		if _, exists := cfg.Profiles[name]; exists && !importReplaceFlag {
+			return fmt.Errorf("profile '%s' already exists; use --as to import it under another name or --replace to replace it", name)
+		}
+		cfg.Profiles[name] = exp.Profile
+		if err := cfg.save(); err != nil {
+			return fmt.Errorf("error saving config: %w", err)
+		}
+		fmt.Printf("Profile '%s' imported.\n", name)
+		return nil
+	},
+}
@@ -0,0 +1,249 @@
+// exporter.go
+// Prometheus exporter serving the settings and sensors in the text exposition format.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// metricWriter writes metrics in the Prometheus text format (version 0.0.4).
+// Every family is started with family() and followed by all of its samples.
+type metricWriter struct {
+	w io.Writer
+}
+
+func (m metricWriter) family(name, kind, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// sample writes one sample; labels are name/value pairs.
+func (m metricWriter) sample(name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, `%s="%s"`, labels[i], metricLabelEscaper.Replace(labels[i+1]))
+		}
+		b.WriteByte('}')
+	}
+	fmt.Fprintf(m.w, "%s %s\n", b.String(), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// exporter serves /metrics. Scrapes are serialized, as the power and frequency
+// meters average over the time since the previous scrape.
+type exporter struct {
+	mu        sync.Mutex
+	session   *telemetrySession
+	hwmonRoot string
+	observed  map[string]int // scrapes that saw each limiter throttling
+}
+
+func newExporter(session *telemetrySession, hwmonRoot string) *exporter {
+	return &exporter{session: session, hwmonRoot: hwmonRoot, observed: map[string]int{}}
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	f := e.session.frame()
+	hwmon, err := readHwmonTemps(e.hwmonRoot)
+	if err != nil {
+		f.Errors = append(f.Errors, "hwmon: "+err.Error())
+	}
+	if f.Throttle != nil {
+		for _, l := range f.Throttle.Active {
+			e.observed[l]++
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w, f, hwmon, e.observed)
+}
+
+// writeMetrics prints a telemetry frame as metrics. Settings that could not be
+// read are left out, and counted in undervolt_read_errors.
+func writeMetrics(w io.Writer, f telemetryFrame, hwmon []TempReading, observed map[string]int) {
+	m := metricWriter{w}
+
+	if p := f.Live; p != nil {
+		m.family("undervolt_offset_millivolts", "gauge", "Voltage offset of the plane.")
+		for _, plane := range planes {
+			if mV, ok := p.Planes[plane.Name]; ok {
+				m.sample("undervolt_offset_millivolts", mV, "plane", plane.Name)
+			}
+		}
+
+		limits := []struct {
+			name string
+			pl   *PowerLimitSetting
+		}{{"p1", p.P1}, {"p2", p.P2}}
+		m.family("undervolt_power_limit_enabled", "gauge", "Whether the package power limit is enabled.")
+		for _, l := range limits {
+			m.sample("undervolt_power_limit_enabled", boolToFloat(l.pl != nil), "limit", l.name)
+		}
+		m.family("undervolt_power_limit_watts", "gauge", "Package power limit, when enabled.")
+		for _, l := range limits {
+			if l.pl != nil {
+				m.sample("undervolt_power_limit_watts", l.pl.PowerW, "limit", l.name)
+			}
+		}
+		m.family("undervolt_power_limit_window_seconds", "gauge", "Time window of the package power limit, when enabled.")
+		for _, l := range limits {
+			if l.pl != nil {
+				m.sample("undervolt_power_limit_window_seconds", l.pl.TimeS, "limit", l.name)
+			}
+		}
+		if p.Lock != nil {
+			m.family("undervolt_power_limit_locked", "gauge", "Whether the power limit register is locked until reset.")
+			m.sample("undervolt_power_limit_locked", boolToFloat(*p.Lock))
+		}
+
+		target := p.Temp
+		if target == nil {
+			target = p.TempBat
+		}
+		if target != nil {
+			m.family("undervolt_temperature_target_celsius", "gauge", "Temperature at which the CPU starts throttling (TCC activation).")
+			m.sample("undervolt_temperature_target_celsius", float64(*target))
+		}
+		if p.Turbo != nil {
+			m.family("undervolt_turbo_enabled", "gauge", "Whether Intel Turbo Boost is enabled.")
+			m.sample("undervolt_turbo_enabled", boolToFloat(*p.Turbo))
+		}
+	}
+
+	m.family("undervolt_on_battery", "gauge", "Whether the system runs on battery.")
+	m.sample("undervolt_on_battery", boolToFloat(f.PowerSource == powerSourceBattery))
+	if f.Profile != "" {
+		m.family("undervolt_profile_active", "gauge", "Saved profile whose settings are all active.")
+		m.sample("undervolt_profile_active", 1, "profile", f.Profile)
+	}
+
+	if len(f.Power) > 0 {
+		m.family("undervolt_power_watts", "gauge", "Average RAPL power since the previous scrape.")
+		for _, p := range f.Power {
+			m.sample("undervolt_power_watts", p.Watts, "domain", p.Domain)
+		}
+	}
+
+	m.family("undervolt_temperature_celsius", "gauge", "Temperature sensor reading.")
+	for _, t := range hwmon {
+		m.sample("undervolt_temperature_celsius", t.Celsius, "chip", t.Chip, "device", t.Device, "sensor", t.Label)
+	}
+	labels := make([]string, 0, len(f.Temps))
+	for label := range f.Temps {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		m.sample("undervolt_temperature_celsius", f.Temps[label], "chip", "msr", "sensor", label)
+	}
+
+	if len(f.Freqs) > 0 {
+		m.family("undervolt_cpu_frequency_mhz", "gauge", "Average effective frequency of the CPU since the previous scrape.")
+		for _, cpu := range f.CPUs {
+			if fr, ok := f.Freqs[cpu]; ok {
+				m.sample("undervolt_cpu_frequency_mhz", fr.AvgMHz, "cpu", strconv.Itoa(cpu))
+			}
+		}
+		m.family("undervolt_cpu_busy_ratio", "gauge", "Share of the time the CPU was busy (C0) since the previous scrape.")
+		for _, cpu := range f.CPUs {
+			if fr, ok := f.Freqs[cpu]; ok {
+				m.sample("undervolt_cpu_busy_ratio", fr.BusyPct/100, "cpu", strconv.Itoa(cpu))
+			}
+		}
+	}
+
+	if t := f.Throttle; t != nil {
+		m.family("undervolt_throttle_active", "gauge", "Whether the limiter throttles the CPU.")
+		for _, l := range limiterOrder {
+			m.sample("undervolt_throttle_active", boolToFloat(slices.Contains(t.Active, l)), "limiter", l)
+		}
+		m.family("undervolt_throttle_logged", "gauge", "Whether the limiter throttled the CPU since its log bit was last cleared.")
+		for _, l := range limiterOrder {
+			m.sample("undervolt_throttle_logged", boolToFloat(slices.Contains(t.Logged, l)), "limiter", l)
+		}
+	}
+	m.family("undervolt_throttle_observed_total", "counter", "Scrapes that found the limiter throttling the CPU.")
+	for _, l := range limiterOrder {
+		m.sample("undervolt_throttle_observed_total", float64(observed[l]), "limiter", l)
+	}
+
+	m.family("undervolt_read_errors", "gauge", "Settings and sensors that could not be read during the scrape.")
+	m.sample("undervolt_read_errors", float64(len(f.Errors)))
+}
+
+// ---------- Exporter Command ----------
+
+var exporterListenFlag string
+
+// newExporterMux routes /metrics to exp and links to it from /.
+func newExporterMux(exp http.Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exp)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, `<html><body><a href="/metrics">Metrics</a></body></html>`)
+	})
+	return mux
+}
+
+var exporterCmd = &cobra.Command{
+	Use:   "exporter",
+	Short: "Serve the settings and sensors as Prometheus metrics",
+	Long: "Serve /metrics for Prometheus with the voltage offsets, power limits, lock state, temperature target, " +
+		"turbo state, RAPL power, temperatures, frequencies and throttle status, read on every scrape. " +
+		"Alert on undervolt_offset_millivolts or undervolt_profile_active to notice settings lost after resume.",
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		setupLogging()
+		exp := newExporter(newTelemetrySession(openMSRDevice(), ADDRESSES), hwmonRoot)
+		srv := &http.Server{Addr: exporterListenFlag, Handler: newExporterMux(exp), ReadHeaderTimeout: 10 * time.Second}
+
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(stop)
+		errc := make(chan error, 1)
+		go func() { errc <- srv.ListenAndServe() }()
+		fmt.Fprintf(os.Stderr, "Serving metrics on http://%s/metrics\n", exporterListenFlag)
+
+		select {
+		case err := <-errc:
+			return err
+		case <-stop:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			return srv.Shutdown(ctx)
+		}
+	},
+}
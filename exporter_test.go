@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// parseExposition returns the samples of a text exposition by series, failing
+// on duplicate series and on samples outside their declared family.
+func parseExposition(t *testing.T, r io.Reader) map[string]float64 {
+	t.Helper()
+	samples := map[string]float64{}
+	declared := map[string]bool{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if name, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			declared[strings.Fields(name)[0]] = true
+			continue
+		}
+		if strings.HasPrefix(line, "#") || line == "" {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		series := line[:i]
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Errorf("invalid sample %q: %v", line, err)
+			continue
+		}
+		name, _, _ := strings.Cut(series, "{")
+		if !declared[name] {
+			t.Errorf("sample %q before the TYPE of its family", line)
+		}
+		if _, dup := samples[series]; dup {
+			t.Errorf("duplicate series %s", series)
+		}
+		samples[series] = value
+	}
+	return samples
+}
+
+func TestExporterMetrics(t *testing.T) {
+	dev := newSimMSR(2)
+	withFlags(t, func() { setPlaneFlag("core", -80) })
+	if err := applyFlags(dev); err != nil {
+		t.Fatalf("applyFlags: %v", err)
+	}
+
+	srv := httptest.NewServer(newExporterMux(newExporter(newTelemetrySession(dev, ADDRESSES), testHwmonRoot)))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/metric")
+	if err != nil {
+		t.Fatalf("GET /metric: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /metric: status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+	resp, err = http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	samples := parseExposition(t, resp.Body)
+
+	want := map[string]float64{
+		`undervolt_offset_millivolts{plane="core"}`:                                      -80,
+		`undervolt_offset_millivolts{plane="cache"}`:                                     0,
+		`undervolt_power_limit_enabled{limit="p1"}`:                                      1,
+		`undervolt_power_limit_watts{limit="p1"}`:                                        45,
+		`undervolt_temperature_target_celsius`:                                           100,
+		`undervolt_temperature_celsius{chip="coretemp",device="hwmon1",sensor="Core 0"}`: 43,
+		`undervolt_temperature_celsius{chip="nvme",device="hwmon4",sensor="Composite"}`:  35.85,
+		`undervolt_temperature_celsius{chip="nvme",device="hwmon5",sensor="Composite"}`:  41.85,
+		`undervolt_throttle_observed_total{limiter="thermal"}`:                           0,
+	}
+	for series, value := range want {
+		got, ok := samples[series]
+		if !ok {
+			t.Errorf("missing %s", series)
+			continue
+		}
+		// The mailbox stores offsets in steps of 1/1.024 mV
+		if math.Abs(got-value) > 0.5 {
+			t.Errorf("%s = %v, want %v", series, got, value)
+		}
+	}
+}
@@ -15,6 +15,8 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"image/color"
 	"os"
@@ -34,7 +36,6 @@ import (
 	"fyne.io/fyne/v2/layout"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
-	"github.com/spf13/viper"
 )
 
 // Used in main.go by rootCmd
@@ -152,7 +153,7 @@ type AppGUI struct {
 	persistCheck *widget.Check
 
 	// Profile Selects
-	profileSaveSelect *widget.Select
+	profileSaveSelect *widget.SelectEntry
 	profileLoadSelect *widget.Select
 
 	// Monitoring State
@@ -209,12 +210,15 @@ func (g *AppGUI) initWidgets() {
 	g.outputLog.Selectable = true
 
 	// Planes
-	g.planes = []planeUI{
-		{"Core", "core", newInfoEntry("Voltage offset for Core plane (e.g., -50.000 mV)", g.showWarning), newInfoCheck("", "Enable undervolt for Core plane", g.showWarning)},
-		{"Cache", "cache", newInfoEntry("Voltage offset for Cache plane (e.g., -50.000 mV)", g.showWarning), newInfoCheck("", "Enable undervolt for Cache plane", g.showWarning)},
-		{"GPU", "gpu", newInfoEntry("Voltage offset for GPU plane (e.g., -50.000 mV)", g.showWarning), newInfoCheck("", "Enable undervolt for GPU plane", g.showWarning)},
-		{"Uncore", "uncore", newInfoEntry("Voltage offset for Uncore plane (e.g., -50.000 mV)", g.showWarning), newInfoCheck("", "Enable undervolt for Uncore plane", g.showWarning)},
-		{"AnalogIO", "analogio", newInfoEntry("Voltage offset for AnalogIO plane (e.g., -50.000 mV)", g.showWarning), newInfoCheck("", "Enable undervolt for AnalogIO plane", g.showWarning)},
+	// planes is defined in main.go
+	g.planes = make([]planeUI, 0, len(planes))
+	for _, p := range planes {
+		g.planes = append(g.planes, planeUI{
+			p.Label,
+			p.Name,
+			newInfoEntry(fmt.Sprintf("Voltage offset for %s plane (%s), e.g., -50.000 mV", p.Label, p.Description), g.showWarning),
+			newInfoCheck("", "Enable undervolt for "+p.Label+" plane", g.showWarning),
+		})
 	}
 
 	floatValidator := func(s string) error {
@@ -291,8 +295,22 @@ func (g *AppGUI) initWidgets() {
 	)
 
 	// Profiles
-	g.profileSaveSelect = widget.NewSelect([]string{"AC", "Battery"}, nil)
-	g.profileLoadSelect = widget.NewSelect([]string{"Auto", "AC", "Battery"}, nil)
+	g.profileSaveSelect = widget.NewSelectEntry(nil)
+	g.profileSaveSelect.SetPlaceHolder("Profile name")
+	g.profileLoadSelect = widget.NewSelect(nil, nil)
+	g.refreshProfiles()
+}
+
+// refreshProfiles reloads the config and fills the profile selects with the saved profiles
+func (g *AppGUI) refreshProfiles() {
+	cfg, err := loadConfig()
+	if err != nil {
+		g.showWarning("Could not load profiles: "+err.Error(), 5*time.Second)
+		return
+	}
+	names := cfg.profileNames()
+	g.profileSaveSelect.SetOptions(names)
+	g.profileLoadSelect.SetOptions(append([]string{"Auto"}, names...))
 }
 
 // ---------------------------------------------------------------------
@@ -335,17 +353,24 @@ func (g *AppGUI) showWarning(msg string, duration time.Duration) {
 	g.appendToLog(fmt.Sprintf("info: %s", msg))
 }
 
-// Collect flags from input elements and return as array
-func (g *AppGUI) collect() []string {
-	var args []string
+// collectProfile builds a profile from the input elements. Empty inputs are left unset.
+func (g *AppGUI) collectProfile() (*Profile, error) {
+	p := &Profile{}
 	var outputLabelAlertArray []string
 
-	for _, p := range g.planes {
-		if p.check.Checked {
-			args = append(args, "--"+p.command+"="+p.entry.Text)
-		} else {
-			outputLabelAlertArray = append(outputLabelAlertArray, "Voltage offset for "+p.name+" was not applied as the corresponding checkbox is unchecked.\n")
+	for _, plane := range g.planes {
+		if !plane.check.Checked {
+			outputLabelAlertArray = append(outputLabelAlertArray, "Voltage offset for "+plane.name+" was not applied as the corresponding checkbox is unchecked.\n")
+			continue
+		}
+		mV, err := strToFloat64(plane.entry.Text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", plane.name, err)
+		}
+		if p.Planes == nil {
+			p.Planes = map[string]float64{}
 		}
+		p.Planes[plane.command] = mV
 	}
 	if len(outputLabelAlertArray) > 0 {
 		fullMsg := strings.Join(outputLabelAlertArray, "\n")
@@ -353,71 +378,79 @@ func (g *AppGUI) collect() []string {
 		g.showWarning("Error occured when applying Voltage Offset settings. Please check 'Log' pane for more information.", 3*time.Second)
 	}
 
-	if g.forceCheck.Checked {
-		args = append(args, "--force")
-	}
 	if g.lockCheck.Checked {
-		args = append(args, "--lock-power-limit")
-	}
-	if g.verboseCheck.Checked {
-		args = append(args, "--verbose")
-	}
-	if val, ok := g.turboOptions[g.turboSelect.Selected]; ok {
-		args = append(args, "--turbo="+val)
+		lock := true
+		p.Lock = &lock
 	}
-	if g.tempEntry.Text != "" {
-		args = append(args, "--temp="+g.tempEntry.Text)
+	switch g.turboOptions[g.turboSelect.Selected] {
+	case "0":
+		enabled := true
+		p.Turbo = &enabled
+	case "1":
+		enabled := false
+		p.Turbo = &enabled
 	}
-	if g.tempBatEntry.Text != "" {
-		args = append(args, "--temp-bat="+g.tempBatEntry.Text)
-	}
-	if g.p1Power.Text != "" && g.p1Time.Text != "" {
-		args = append(args, "--p1="+g.p1Power.Text+","+g.p1Time.Text)
-	} else if g.p1Power.Text == "" || g.p1Time.Text == "" {
-		g.appendToLog("Please specify both Power and Time for P1.")
-	}
-	if g.p2Power.Text != "" && g.p2Time.Text != "" {
-		args = append(args, "--p2="+g.p2Power.Text+","+g.p2Time.Text)
-	} else if g.p2Power.Text == "" || g.p2Time.Text == "" {
-		g.appendToLog("Please specify both Power and Time for P2.")
+	for _, t := range []struct {
+		entry *infoEntry
+		dst   **int
+	}{{g.tempEntry, &p.Temp}, {g.tempBatEntry, &p.TempBat}} {
+		if t.entry.Text == "" {
+			continue
+		}
+		temp, err := strconv.Atoi(t.entry.Text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid temperature %q", t.entry.Text)
+		}
+		*t.dst = &temp
 	}
-	if g.persistCheck.Checked {
-		args = append(args, "--persist")
+	for _, pl := range []struct {
+		term        string
+		power, time *infoEntry
+		dst         **PowerLimitSetting
+	}{{"P1", g.p1Power, g.p1Time, &p.P1}, {"P2", g.p2Power, g.p2Time, &p.P2}} {
+		if pl.power.Text == "" || pl.time.Text == "" {
+			g.appendToLog("Please specify both Power and Time for " + pl.term + ".")
+			continue
+		}
+		setting, err := powerLimitFromArgs(pl.term, []string{pl.power.Text, pl.time.Text})
+		if err != nil {
+			return nil, err
+		}
+		*pl.dst = setting
 	}
-	return args
+	return p, p.validate()
 }
 
-func (g *AppGUI) run(flags ...string) error {
-	cmd := exec.Command("sudo", append([]string{rootCmdUseString}, flags...)...)
-
-	var outBuilder, errBuilder strings.Builder
-	cmd.Stdout = &outBuilder
-	cmd.Stderr = &errBuilder
-
-	// Run the command (blocks until complete)
-	g.showWarning(fmt.Sprintf("Please wait: Running undervolt-go-pro with flags:\n%s.", strings.Join(flags, " ")), 3*time.Second)
-	err := cmd.Run()
-
-	// Show stdout in the label binding
-	g.outputLabelBinding.Set(outBuilder.String())
-
-	// Show stderr in the log (if there is any)
-	if errOutput := errBuilder.String(); errOutput != "" {
-		g.appendToLog("Stderr: " + errOutput)
+// request sends req to the undervolt-go service, or, when the service is not
+// reachable, runs it through pkexec, and reports the outcome in the log.
+func (g *AppGUI) request(req APIRequest) (APIResponse, error) {
+	if g.verboseCheck.Checked {
+		if data, err := json.Marshal(req); err == nil {
+			g.appendToLog("Request: " + string(data))
+		}
+	}
+	g.showWarning(fmt.Sprintf("Please wait: Running '%s'.", req.Op), 3*time.Second)
+	resp, err := apiCall(apiSocketPath, req)
+	if errors.Is(err, errAPIUnreachable) {
+		if g.verboseCheck.Checked {
+			g.appendToLog(err.Error() + "; asking polkit for " + polkitActionFor(req))
+		}
+		resp, err = pkexecCall(req)
 	}
-
-	// Handle execution errors (e.g., command not found, or non-zero exit status)
 	if err != nil {
-		g.appendToLog("Execution Error: " + err.Error())
-		dialog.ShowError(fmt.Errorf("Error occurred when applying settings. Please check 'Log' pane for more information."), g.window)
+		g.appendToLog("Service Error: " + err.Error())
+		dialog.ShowError(fmt.Errorf("Error occurred when running '%s'. Please check 'Log' pane for more information.", req.Op), g.window)
+		return resp, err
 	}
-
-	return err
+	if resp.Message != "" {
+		g.appendToLog(resp.Message)
+	}
+	return resp, nil
 }
 
-// startMonitor spins up a goroutine that every second runs `command`
-// and dumps its stdout/stderr into outputLabel (including any errors).
-func (g *AppGUI) startMonitor(command string) {
+// startMonitor spins up a goroutine that every second calls `sample`
+// and shows the text it returns in outputLabel.
+func (g *AppGUI) startMonitor(sample func() string) {
 	if g.monitorTicker != nil {
 		return // already running
 	}
@@ -431,20 +464,27 @@ func (g *AppGUI) startMonitor(command string) {
 			case <-stop:
 				return
 			case <-ticker.C:
-				// run via shell so pipes/greps work
-				cmd := exec.Command("sh", "-c", command)
-				var buf bytes.Buffer
-				cmd.Stdout = &buf
-				cmd.Stderr = &buf
-				if err := cmd.Run(); err != nil {
-					buf.WriteString("\nError: " + err.Error())
-				}
-				g.outputLabelBinding.Set(buf.String())
+				g.outputLabelBinding.Set(sample())
 			}
 		}
 	}(g.stopMonitor, g.monitorTicker)
 }
 
+// sampleTemps reads the temperatures natively, from hwmon and (as root) the thermal MSRs.
+func sampleTemps() string {
+	dev, devErr := sensorMSRDevice()
+	var buf bytes.Buffer
+	writeTempsText(&buf, readTemps(hwmonRoot, dev, devErr))
+	return buf.String()
+}
+
+// sampleFans reads the fan speeds natively, from hwmon and the ThinkPad fan interface.
+func sampleFans() string {
+	var buf bytes.Buffer
+	writeFansText(&buf, readFans(hwmonRoot, thinkpadFanPath))
+	return buf.String()
+}
+
 // stopMonitorFunc tells that goroutine to exit
 func (g *AppGUI) stopMonitorFunc() {
 	if g.stopMonitor != nil {
@@ -526,9 +566,13 @@ func (g *AppGUI) buildOtherFlagsTab() fyne.CanvasObject {
 
 func (g *AppGUI) buildProfilesBar() fyne.CanvasObject {
 	profileSaveBtn := widget.NewButton("Save", func() {
-		name := g.profileSaveSelect.Selected
+		name := strings.ToLower(g.profileSaveSelect.Text)
 		if name == "" {
-			g.showWarning("Please select a profile to save to.", 3*time.Second)
+			g.showWarning("Please select or type a profile to save to.", 3*time.Second)
+			return
+		}
+		if err := checkProfileName(name); err != nil {
+			g.showWarning(err.Error(), 3*time.Second)
 			return
 		}
 
@@ -537,15 +581,16 @@ func (g *AppGUI) buildProfilesBar() fyne.CanvasObject {
 			fmt.Sprintf("Saving the values specified to profile: %s. This will overwrite any existing values in the corresponding profile.\n\nNote: If you want to see the current values in the profile without losing the values that you have specified, open Undervolt Go as a new window and load the profile there.\n\nProceed?", name), // description
 			func(confirmed bool) { // function callback
 				if confirmed {
-					args := g.collect()
-					flags := append([]string{"profile", "save", strings.ToLower(name)}, args...)
-					if len(args) > 0 {
-						if err := g.run(flags...); err == nil {
-							g.showWarning("Settings saved successfully as profile "+name+".", 3*time.Second)
-						} else {
-							// Handle error if g.run fails
-							g.showWarning("Failed to save to profile: "+err.Error(), 3*time.Second)
-						}
+					p, err := g.collectProfile()
+					if err != nil {
+						g.showWarning("Failed to save to profile: "+err.Error(), 3*time.Second)
+						return
+					}
+					if _, err := g.request(APIRequest{Op: apiOpProfileSave, Name: name, Profile: p}); err == nil {
+						g.refreshProfiles()
+						g.showWarning("Settings saved successfully as profile "+name+".", 3*time.Second)
+					} else {
+						g.showWarning("Failed to save to profile: "+err.Error(), 3*time.Second)
 					}
 				} else {
 					g.showWarning(fmt.Sprintf("Saving to 'profile: %s' cancelled by user", name), 3*time.Second)
@@ -562,14 +607,15 @@ func (g *AppGUI) buildProfilesBar() fyne.CanvasObject {
 			return
 		}
 
+		// Reload config from disk to catch updated profiles
+		cfg, err := loadConfig()
+		if err != nil {
+			g.showWarning("Could not load profiles: "+err.Error(), 5*time.Second)
+			return
+		}
 		actualName := name
 		if actualName == "Auto" {
-			// isBatteryDischarging is available main.go
-			if isBatteryDischarging() {
-				actualName = "Battery"
-			} else {
-				actualName = "AC"
-			}
+			actualName = cfg.autoSwitchProfile(currentPowerSource())
 		}
 
 		dialog.ShowConfirm(
@@ -577,80 +623,55 @@ func (g *AppGUI) buildProfilesBar() fyne.CanvasObject {
 			fmt.Sprintf("Loading profile: %s. This will overwrite any existing values that you may have specified in the fields.\n\nProceed?", actualName),
 			func(confirmed bool) {
 				if confirmed {
-					// Reload config from disk to catch updated profiles
-					// initConfig is available in package main.go
-					initConfig()
-
-					key := "profiles." + strings.ToLower(actualName)
-					if !viper.IsSet(key) {
+					p, err := cfg.profile(actualName)
+					if err != nil {
 						g.showWarning(fmt.Sprintf("Profile '%s' not found.", actualName), 3*time.Second)
 						return
 					}
 
-					// Get the values from the profile
-					p := viper.Sub(key)
-					coreOffset := p.GetFloat64("planes.core")
-					gpuOffset := p.GetFloat64("planes.gpu")
-					cacheOffset := p.GetFloat64("planes.cache")
-					uncoreOffset := p.GetFloat64("planes.uncore")
-					analogioOffset := p.GetFloat64("planes.analogio")
-					tempFlag := p.GetInt("tl.temp")
-					tempBatFlag := p.GetInt("tl.temp-bat")
-					turboFlag := p.GetInt("turbo")
-					// we use p.GetIntSlice here as the values are int. we couldn't in main.go as the flags were string.
-					p1Args := p.GetIntSlice("pl.p1")
-					p2Args := p.GetIntSlice("pl.p2")
-					/* below code is useful if the values of p1 or p2 array float ... keep it
-					if raw := p.Get("pl.p1"); raw != nil {
-						if arr, ok := raw.([]any); ok && len(arr) == 2 {
-							p1Args = []string{fmt.Sprint(arr[0]), fmt.Sprint(arr[1])}
-						}
-					}
-					if raw := p.Get("pl.p2"); raw != nil {
-						if arr, ok := raw.([]any); ok && len(arr) == 2 {
-							p2Args = []string{fmt.Sprint(arr[0]), fmt.Sprint(arr[1])}
-						}
-					}
-					*/
-
-					// Update the values in the entry widgets
+					// Update the values in the entry widgets; unset values clear the field
 					for _, plane := range g.planes {
-						switch plane.name {
-						case "Core":
-							plane.entry.SetText(fmt.Sprintf("%f", coreOffset))
-						case "Cache":
-							plane.entry.SetText(fmt.Sprintf("%f", cacheOffset))
-						case "GPU":
-							plane.entry.SetText(fmt.Sprintf("%f", gpuOffset))
-						case "Uncore":
-							plane.entry.SetText(fmt.Sprintf("%f", uncoreOffset))
-						case "AnalogIO":
-							plane.entry.SetText(fmt.Sprintf("%f", analogioOffset))
+						if mV, ok := p.Planes[plane.command]; ok {
+							plane.entry.SetText(fmt.Sprintf("%f", mV))
+						} else {
+							plane.entry.SetText("")
 						}
 					}
 
-					if len(p1Args) == 2 {
-						g.p1Power.SetText(fmt.Sprintf("%d", p1Args[0]))
-						g.p1Time.SetText(fmt.Sprintf("%d", p1Args[1]))
+					if p.P1 != nil {
+						g.p1Power.SetText(formatFloat(p.P1.PowerW))
+						g.p1Time.SetText(formatFloat(p.P1.TimeS))
 					} else {
 						g.p1Power.SetText("")
 						g.p1Time.SetText("")
 					}
 
-					if len(p2Args) == 2 {
-						g.p2Power.SetText(fmt.Sprintf("%d", p2Args[0]))
-						g.p2Time.SetText(fmt.Sprintf("%d", p2Args[1]))
+					if p.P2 != nil {
+						g.p2Power.SetText(formatFloat(p.P2.PowerW))
+						g.p2Time.SetText(formatFloat(p.P2.TimeS))
 					} else {
 						g.p2Power.SetText("")
 						g.p2Time.SetText("")
 					}
 
-					g.tempEntry.SetText(fmt.Sprintf("%d", tempFlag))
-					g.tempBatEntry.SetText(fmt.Sprintf("%d", tempBatFlag))
+					g.tempEntry.SetText("")
+					if p.Temp != nil {
+						g.tempEntry.SetText(strconv.Itoa(*p.Temp))
+					}
+					g.tempBatEntry.SetText("")
+					if p.TempBat != nil {
+						g.tempBatEntry.SetText(strconv.Itoa(*p.TempBat))
+					}
 
+					noTurbo := "-1"
+					if p.Turbo != nil && *p.Turbo {
+						noTurbo = "0"
+					} else if p.Turbo != nil {
+						noTurbo = "1"
+					}
 					turboProfile := ""
 					for option, value := range g.turboOptions {
-						if value == strconv.Itoa(turboFlag) {
+						if value == noTurbo {
 							turboProfile = option
 							break
 						}
@@ -670,11 +691,6 @@ func (g *AppGUI) buildProfilesBar() fyne.CanvasObject {
 		)
 	})
 
-	isAutoSwitchEnabled := func() bool {
-		_, err := os.Stat("/etc/udev/rules.d/99-undervolt-go-auto.rules")
-		return err == nil
-	}
-
 	autoSwitchBtn := widget.NewButton("", nil)
 	updateAutoSwitchBtn := func() {
 		if isAutoSwitchEnabled() {
@@ -688,25 +704,29 @@ func (g *AppGUI) buildProfilesBar() fyne.CanvasObject {
 	autoSwitchBtn.OnTapped = func() {
 		dialog.ShowConfirm(
 			"Enable auto-switching profiles",
-			"Enable automatic profile switching based on whether the battery is charging or discharging. Make sure that both AC and Battery profiles exist before enabling.\n\nProceed?",
+			"Enable automatic profile switching based on whether the battery is charging or discharging. Make sure that both the AC and Battery profiles exist before enabling (by default the profiles named 'ac' and 'battery'; pick others with 'undervolt-go profile auto-switch enable --ac <name> --battery <name>').\n\nProceed?",
 			func(confirmed bool) {
 				if confirmed {
-					// Make sure we have the latest config state
-					initConfig()
-
 					if !isAutoSwitchEnabled() {
+						// Make sure we have the latest config state
+						cfg, err := loadConfig()
+						if err != nil {
+							g.showWarning("Could not load profiles: "+err.Error(), 5*time.Second)
+							return
+						}
 						// Check if profiles exist before allowing it to be enabled
-						if !viper.IsSet("profiles.ac") || !viper.IsSet("profiles.battery") {
-							g.showWarning("Both 'AC' and 'Battery' profiles must exist before enabling auto-profile switching.", 4*time.Second)
+						acName, batName := cfg.autoSwitchProfile(powerSourceAC), cfg.autoSwitchProfile(powerSourceBattery)
+						if cfg.Profiles[acName] == nil || cfg.Profiles[batName] == nil {
+							g.showWarning(fmt.Sprintf("Both the AC profile '%s' and the Battery profile '%s' must exist before enabling auto-profile switching.", acName, batName), 4*time.Second)
 							return
 						}
-						if err := g.run("profile", "auto-switch", "enable"); err == nil {
+						if _, err := g.request(APIRequest{Op: apiOpAutoSwitchEnable}); err == nil {
 							g.showWarning("Auto profile switching enabled.", 3*time.Second)
 						} else {
 							g.showWarning("Could not enable auto profile switching: "+err.Error(), 3*time.Second)
 						}
 					} else {
-						if err := g.run("profile", "auto-switch", "disable"); err == nil {
+						if _, err := g.request(APIRequest{Op: apiOpAutoSwitchDisable}); err == nil {
 							g.showWarning("Auto profile switching disabled.", 3*time.Second)
 						} else {
 							g.showWarning("Could not disable auto profile switching: "+err.Error(), 3*time.Second)
@@ -745,7 +765,7 @@ func (g *AppGUI) buildPersistBar() fyne.CanvasObject {
 			"This will remove offsets and other values that are configured to persist across boot and automatically apply on startup.\n\nProceed?",
 			func(confirmed bool) {
 				if confirmed {
-					if err := g.run("--disable-persist"); err == nil {
+					if _, err := g.request(APIRequest{Op: apiOpPersistDisable}); err == nil {
 						g.showWarning("Persisted configuration cleared successfully.", 3*time.Second)
 					} else {
 						g.showWarning("Persisted configuration could not be cleared: "+err.Error(), 3*time.Second)
@@ -772,14 +792,28 @@ func (g *AppGUI) buildStatusTab() fyne.CanvasObject {
 			g.showWarning("Please click 'Stop' before running this command.", 3*time.Second)
 			return
 		}
-		_ = g.run("--read")
+		if resp, err := g.request(APIRequest{Op: apiOpRead}); err == nil {
+			var buf bytes.Buffer
+			writeReportText(&buf, *resp.Report)
+			g.outputLabelBinding.Set(buf.String())
+		}
 	})
 	helpBtn := widget.NewButton("Help", func() {
 		if g.monitorTicker != nil {
 			g.showWarning("Please click 'Stop' before running this command.", 3*time.Second)
 			return
 		}
-		_ = g.run("--help")
+		// Help needs no privileges, so it is not asked from the service
+		exe, err := os.Executable()
+		if err != nil {
+			g.appendToLog("Execution Error: " + err.Error())
+			return
+		}
+		out, err := exec.Command(exe, "--help").Output()
+		if err != nil {
+			g.appendToLog("Execution Error: " + err.Error())
+		}
+		g.outputLabelBinding.Set(string(out))
 	})
 	checkTempsBtn := widget.NewButton("Check Temps", func() {
 		if g.monitorTicker != nil {
@@ -787,7 +821,7 @@ func (g *AppGUI) buildStatusTab() fyne.CanvasObject {
 			return
 		}
 		g.showWarning("Please click 'Stop' before running any other command or closing the app.", 3*time.Second)
-		g.startMonitor("sensors | grep Core")
+		g.startMonitor(sampleTemps)
 	})
 	checkFansBtn := widget.NewButton("Check Fans", func() {
 		if g.monitorTicker != nil {
@@ -795,7 +829,7 @@ func (g *AppGUI) buildStatusTab() fyne.CanvasObject {
 			return
 		}
 		g.showWarning("Please click 'Stop' before running any other command or closing the app.", 3*time.Second)
-		g.startMonitor("sensors | grep -e cpu_fan -e gpu_fan")
+		g.startMonitor(sampleFans)
 	})
 	stopBtn := widget.NewButton("Stop", func() {
 		if g.monitorTicker == nil {
@@ -810,7 +844,7 @@ func (g *AppGUI) buildStatusTab() fyne.CanvasObject {
 			g.showWarning("Please click 'Stop' before running this command.", 3*time.Second)
 			return
 		}
-		_ = g.run("--version")
+		g.outputLabelBinding.Set(fmt.Sprintf("%s version %s\n", rootCmdUseString, version))
 	})
 
 	btnBar := container.NewHBox(
@@ -882,12 +916,14 @@ func (g *AppGUI) buildLayout() {
 			message,
 			func(confirmed bool) {
 				if confirmed {
-					// without len(args) > 0, clicking on apply without any setting relaunches another window of Undervolt Go
-					args := g.collect()
-					if len(args) > 0 {
-						if err := g.run(args...); err == nil {
-							g.showWarning("Settings applied successfully.", 3*time.Second)
-						}
+					p, err := g.collectProfile()
+					if err != nil {
+						g.showWarning("Settings not applied: "+err.Error(), 3*time.Second)
+						return
+					}
+					req := APIRequest{Op: apiOpApply, Profile: p, Force: g.forceCheck.Checked, Persist: g.persistCheck.Checked}
+					if _, err := g.request(req); err == nil {
+						g.showWarning("Settings applied successfully.", 3*time.Second)
 					}
 				} else {
 					g.showWarning("Settings not applied. Cancelled by user.", 3*time.Second)
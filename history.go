@@ -0,0 +1,266 @@
+// history.go
+// Rotating history of register changes with list and undo commands.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Number of history entries kept; older ones are removed. Repeated applies of the
+// same settings (boot, resume, auto-switch, daemon) are not recorded, so they do
+// not push the user's own changes out.
+const historyLimit = 50
+
+func historyDir() string {
+	return filepath.Join(configDir(), "history")
+}
+
+// HistoryEntry records one modification of the hardware state.
+type HistoryEntry struct {
+	Time    time.Time     `yaml:"time"`
+	User    string        `yaml:"user"`
+	Command string        `yaml:"command"`
+	Before  HardwareState `yaml:"before"`
+	After   HardwareState `yaml:"after"`
+}
+
+// invokingUser returns the user who ran the command, looking through sudo.
+func invokingUser() string {
+	if su := os.Getenv("SUDO_USER"); su != "" {
+		return su
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return strconv.Itoa(os.Getuid())
+}
+
+// changeOrigin says who a change is made for, when that is not the user running
+// the command: the services record the client of each request.
+type changeOrigin struct {
+	User    string
+	Command string
+}
+
+// requestOrigin is the origin of the request being executed, nil outside of
+// requests. The services set it around each request, which they serialize.
+var requestOrigin *changeOrigin
+
+// uidUser returns the name of the user with the given uid, or the uid.
+func uidUser(uid uint32) string {
+	id := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(id); err == nil {
+		return u.Username
+	}
+	return id
+}
+
+// trackChange captures the state before a modification. The returned function
+// captures the state afterwards and appends both to the history; it is meant to
+// be deferred so that failed, partially applied changes are recorded as well.
+func trackChange(dev MSRDevice, msr MSR) (HardwareState, func()) {
+	if !recordsState() {
+		return HardwareState{}, func() {}
+	}
+	before := captureState(dev, msr)
+	return before, func() {
+		entry := HistoryEntry{
+			Time:    time.Now(),
+			User:    invokingUser(),
+			Command: strings.Join(append([]string{filepath.Base(os.Args[0])}, os.Args[1:]...), " "),
+			Before:  before,
+			After:   captureState(dev, msr),
+		}
+		if requestOrigin != nil {
+			entry.User, entry.Command = requestOrigin.User, requestOrigin.Command
+		}
+		if sameHardwareState(entry.Before, entry.After) {
+			return
+		}
+		// Reapplying the settings of the last entry (e.g. after a reboot reset the registers)
+		if last, err := loadHistoryEntry(1); err == nil && sameHardwareState(last.After, entry.After) {
+			return
+		}
+		if err := appendHistory(entry); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not record change history: %v\n", err)
+		}
+	}
+}
+
+// sameHardwareState reports whether a and b hold the same values, ignoring when they were captured.
+func sameHardwareState(a, b HardwareState) bool {
+	a.Time, b.Time = time.Time{}, time.Time{}
+	// A loaded entry has no map where a captured state has an empty one
+	if len(a.Planes) == 0 && len(b.Planes) == 0 {
+		a.Planes, b.Planes = nil, nil
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// appendHistory writes entry to the history directory and drops the oldest entries.
+func appendHistory(entry HistoryEntry) error {
+	if err := os.MkdirAll(historyDir(), 0755); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	name := entry.Time.UTC().Format("20060102T150405.000000000Z") + ".yaml"
+	if err := os.WriteFile(filepath.Join(historyDir(), name), data, 0644); err != nil {
+		return err
+	}
+
+	files, err := historyFiles()
+	if err != nil {
+		return err
+	}
+	for len(files) > historyLimit {
+		if err := os.Remove(files[len(files)-1]); err != nil {
+			return err
+		}
+		files = files[:len(files)-1]
+	}
+	return nil
+}
+
+// historyFiles returns the history files, newest first.
+func historyFiles() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(historyDir(), "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	// File names are UTC timestamps, so lexical order is chronological.
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	return files, nil
+}
+
+// loadHistoryEntry reads entry n (1 = most recent).
+func loadHistoryEntry(n int) (HistoryEntry, error) {
+	var entry HistoryEntry
+	files, err := historyFiles()
+	if err != nil {
+		return entry, err
+	}
+	if n < 1 || n > len(files) {
+		return entry, fmt.Errorf("history entry %d does not exist (%d entries recorded)", n, len(files))
+	}
+	data, err := os.ReadFile(files[n-1])
+	if err != nil {
+		return entry, err
+	}
+	if err := yaml.Unmarshal(data, &entry); err != nil {
+		return entry, fmt.Errorf("%s: %w", files[n-1], err)
+	}
+	return entry, nil
+}
+
+// describeStateChange summarizes what differs between two states.
+func describeStateChange(before, after HardwareState, msr MSR) string {
+	var changes []string
+	for _, p := range planes {
+		b, okB := before.Planes[p.Name]
+		a, okA := after.Planes[p.Name]
+		if okB && okA && math.Abs(a-b) > 0.001 {
+			changes = append(changes, fmt.Sprintf("%s %.2f→%.2f mV", p.Name, b, a))
+		}
+	}
+	if before.TempTarget != nil && after.TempTarget != nil {
+		mask := tccOffsetMask(msr)
+		b, a := (uint64(*before.TempTarget)&mask)>>24, (uint64(*after.TempTarget)&mask)>>24
+		if a != b {
+			changes = append(changes, fmt.Sprintf("tcc offset -%d→-%d", b, a))
+		}
+	}
+	if before.PowerLimits != nil && after.PowerLimits != nil && *before.PowerLimits != *after.PowerLimits {
+		changes = append(changes, fmt.Sprintf("power limits 0x%x→0x%x", uint64(*before.PowerLimits), uint64(*after.PowerLimits)))
+	}
+	if before.NoTurbo != nil && after.NoTurbo != nil && *before.NoTurbo != *after.NoTurbo {
+		changes = append(changes, fmt.Sprintf("no_turbo %d→%d", *before.NoTurbo, *after.NoTurbo))
+	}
+	if len(changes) == 0 {
+		return "no change"
+	}
+	return strings.Join(changes, ", ")
+}
+
+// ---------- History Commands ----------
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show or undo recorded changes",
+}
+
+var historyListCmd = &cobra.Command{
+	Use:         "list",
+	Annotations: map[string]string{privilegesAnnotation: privilegesNone},
+	Short:       "List recorded changes, most recent first",
+	Args:        cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		files, err := historyFiles()
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			fmt.Println("No changes recorded.")
+			return nil
+		}
+		for n := 1; n <= len(files); n++ {
+			entry, err := loadHistoryEntry(n)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%3d  error: %v\n", n, err)
+				continue
+			}
+			fmt.Printf("%3d  %s  %-10s %s\n     %s\n", n, entry.Time.Local().Format("2006-01-02 15:04:05"),
+				entry.User, entry.Command, describeStateChange(entry.Before, entry.After, ADDRESSES))
+		}
+		return nil
+	},
+}
+
+var historyUndoCmd = &cobra.Command{
+	Use:   "undo [N]",
+	Short: "Restore the state from before recorded change N (default 1, the most recent)",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		setupLogging()
+		n := 1
+		if len(args) == 1 {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil {
+				return fmt.Errorf("invalid history entry %q", args[0])
+			}
+		}
+		entry, err := loadHistoryEntry(n)
+		if err != nil {
+			return err
+		}
+
+		dev := openMSRDevice()
+		msr := ADDRESSES
+		before, record := trackChange(dev, msr)
+		if err := ensureSnapshot(before); err != nil {
+			return fmt.Errorf("could not save snapshot of current values: %w", err)
+		}
+		defer record()
+
+		if err := restoreState(dev, msr, entry.Before); err != nil {
+			return fmt.Errorf("undo incomplete: %w", err)
+		}
+		fmt.Printf("Restored state from before change %d (%s).\n", n, entry.Time.Local().Format("2006-01-02 15:04:05"))
+		return nil
+	},
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSameHardwareStateAfterRoundTrip(t *testing.T) {
+	dev := newSimMSR(1)
+	withFlags(t, func() { setPlaneFlag("core", -80); tempFlag = 90 })
+	if err := applyFlags(dev); err != nil {
+		t.Fatalf("applyFlags: %v", err)
+	}
+	applied := captureState(dev, ADDRESSES)
+
+	// The entry of the first apply, as read back from the history directory
+	data, err := yaml.Marshal(HistoryEntry{Time: time.Now(), After: applied})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last HistoryEntry
+	if err := yaml.Unmarshal(data, &last); err != nil {
+		t.Fatal(err)
+	}
+
+	reapplied := captureState(dev, ADDRESSES)
+	if !sameHardwareState(last.After, reapplied) {
+		t.Errorf("reapplied state %+v differs from the recorded %+v", reapplied, last.After)
+	}
+	setPlaneFlag("core", -60)
+	if err := applyFlags(dev); err != nil {
+		t.Fatalf("applyFlags: %v", err)
+	}
+	if sameHardwareState(last.After, captureState(dev, ADDRESSES)) {
+		t.Error("a changed offset compares equal to the recorded state")
+	}
+}
@@ -0,0 +1,273 @@
+// log.go
+// Timestamped telemetry logging to CSV or JSON lines, for benchmark runs.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Log formats.
+const (
+	logFormatCSV   = "csv"
+	logFormatJSONL = "jsonl"
+)
+
+// TelemetryRecord is one line of the log.
+type TelemetryRecord struct {
+	Time        time.Time          `json:"time"`
+	PowerSource string             `json:"power_source"`
+	Profile     string             `json:"profile"` // saved profile matching the settings, "" if none
+	TempsC      map[string]float64 `json:"temps_c"` // by sensor: cpu0, cpu1, ..., package
+	PowerW      map[string]float64 `json:"power_w"` // by RAPL domain
+	CPUs        []CPUFrequency     `json:"cpus"`
+	Throttling  *ThrottleStatus    `json:"throttling,omitempty"`
+	Settings    *Profile           `json:"settings"`
+	Errors      []string           `json:"errors,omitempty"`
+}
+
+// round rounds v to the given number of decimals.
+func round(v float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(v*p) / p
+}
+
+// newTelemetryRecord converts a frame, rounding the measurements to their precision.
+func newTelemetryRecord(f telemetryFrame) TelemetryRecord {
+	r := TelemetryRecord{
+		Time:        f.Time,
+		PowerSource: f.PowerSource,
+		Profile:     f.Profile,
+		TempsC:      map[string]float64{},
+		PowerW:      map[string]float64{},
+		CPUs:        []CPUFrequency{},
+		Throttling:  f.Throttle,
+		Settings:    f.Live,
+		Errors:      f.Errors,
+	}
+	for label, c := range f.Temps {
+		r.TempsC[strings.ToLower(strings.ReplaceAll(label, " ", ""))] = c
+	}
+	for _, p := range f.Power {
+		r.PowerW[p.Domain] = round(p.Watts, 2)
+	}
+	for _, cpu := range f.CPUs {
+		if fr, ok := f.Freqs[cpu]; ok {
+			r.CPUs = append(r.CPUs, CPUFrequency{cpu, round(fr.AvgMHz, 0), round(fr.BusyPct, 1), round(fr.BusyMHz, 0)})
+		}
+	}
+	return r
+}
+
+// logColumn is one CSV column of a flattened record.
+type logColumn struct {
+	Name, Value string
+}
+
+// flatten returns the CSV columns of r in a stable order.
+func (r TelemetryRecord) flatten() []logColumn {
+	cols := []logColumn{
+		{"time", r.Time.Format("2006-01-02T15:04:05.000Z07:00")},
+		{"power_source", r.PowerSource},
+		{"profile", r.Profile},
+	}
+	sortedKeys := func(m map[string]float64) []string {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		// cpu2 before cpu10
+		sort.Slice(keys, func(i, j int) bool {
+			ni, nj := numericSuffix(keys[i]), numericSuffix(keys[j])
+			if strings.TrimRight(keys[i], "0123456789") == strings.TrimRight(keys[j], "0123456789") && ni != nj {
+				return ni < nj
+			}
+			return keys[i] < keys[j]
+		})
+		return keys
+	}
+	for _, k := range sortedKeys(r.TempsC) {
+		cols = append(cols, logColumn{"temp_c." + k, formatFloat(r.TempsC[k])})
+	}
+	for _, k := range sortedKeys(r.PowerW) {
+		cols = append(cols, logColumn{"power_w." + k, formatFloat(r.PowerW[k])})
+	}
+	for _, f := range r.CPUs {
+		prefix := fmt.Sprintf("cpu%d.", f.CPU)
+		cols = append(cols,
+			logColumn{prefix + "avg_mhz", formatFloat(f.AvgMHz)},
+			logColumn{prefix + "busy_pct", formatFloat(f.BusyPct)},
+			logColumn{prefix + "busy_mhz", formatFloat(f.BusyMHz)},
+		)
+	}
+	if r.Throttling != nil {
+		cols = append(cols,
+			logColumn{"throttled_by", strings.Join(r.Throttling.Active, "|")},
+			logColumn{"throttled_logged", strings.Join(r.Throttling.Logged, "|")},
+		)
+	}
+	if r.Settings != nil {
+		for _, field := range r.Settings.fields() {
+			value := ""
+			switch v := field.Value.(type) {
+			case nil:
+			case float64:
+				value = formatFloat(v)
+			default:
+				value = fmt.Sprint(v)
+			}
+			cols = append(cols, logColumn{"set." + field.Name, value})
+		}
+	}
+	return cols
+}
+
+// logWriter writes records in one of the log formats.
+type logWriter struct {
+	format string
+	out    *bufio.Writer
+	csv    *csv.Writer
+	header []string // CSV columns, taken from the first record
+}
+
+func newLogWriter(w io.Writer, format string) *logWriter {
+	lw := &logWriter{format: format, out: bufio.NewWriter(w)}
+	if format == logFormatCSV {
+		lw.csv = csv.NewWriter(lw.out)
+	}
+	return lw
+}
+
+// write appends r and flushes it, so that an interrupted run keeps every record.
+// CSV rows follow the header of the first record; values that later records
+// lack are left empty and values the first record lacked are dropped.
+func (lw *logWriter) write(r TelemetryRecord) error {
+	if lw.format == logFormatJSONL {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		lw.out.Write(append(data, '\n'))
+		return lw.out.Flush()
+	}
+
+	cols := r.flatten()
+	if lw.header == nil {
+		for _, c := range cols {
+			lw.header = append(lw.header, c.Name)
+		}
+		if err := lw.csv.Write(lw.header); err != nil {
+			return err
+		}
+	}
+	values := make(map[string]string, len(cols))
+	for _, c := range cols {
+		values[c.Name] = c.Value
+	}
+	row := make([]string, len(lw.header))
+	for i, name := range lw.header {
+		row[i] = values[name]
+	}
+	if err := lw.csv.Write(row); err != nil {
+		return err
+	}
+	lw.csv.Flush()
+	if err := lw.csv.Error(); err != nil {
+		return err
+	}
+	return lw.out.Flush()
+}
+
+// ---------- Log Command ----------
+
+var logIntervalFlag, logDurationFlag time.Duration
+var logFormatFlag, logFileFlag string
+
+var logCmd = &cobra.Command{
+	Use:   "log",
+	Short: "Record temperatures, power, frequencies, throttling and settings to a CSV or JSON lines file",
+	Long: "Record timestamped telemetry every --interval until --duration has passed or the command is " +
+		"interrupted: the temperature and effective frequency of every CPU, the RAPL power, the throttle " +
+		"status, the power source, the saved profile matching the live settings, and the settings themselves. " +
+		"CSV has one column per value; JSON lines has one object per sample.",
+	Example: "  undervolt-go log --interval 500ms --format csv --duration 10m -f run.csv",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if logFormatFlag != logFormatCSV && logFormatFlag != logFormatJSONL {
+			return fmt.Errorf("invalid format %q (valid: %s, %s)", logFormatFlag, logFormatCSV, logFormatJSONL)
+		}
+		if logIntervalFlag < 100*time.Millisecond {
+			return fmt.Errorf("--interval must be at least 100ms, got %v", logIntervalFlag)
+		}
+		if logDurationFlag < 0 {
+			return fmt.Errorf("--duration must not be negative, got %v", logDurationFlag)
+		}
+
+		out := os.Stdout
+		if logFileFlag != "" && logFileFlag != "-" {
+			f, err := os.Create(logFileFlag)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+		lw := newLogWriter(out, logFormatFlag)
+
+		setupLogging()
+		s := newTelemetrySession(openMSRDevice(), ADDRESSES)
+		s.frame() // the first frame has no power or frequencies
+
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(stop)
+		ticker := time.NewTicker(logIntervalFlag)
+		defer ticker.Stop()
+		var deadline <-chan time.Time
+		if logDurationFlag > 0 {
+			deadline = time.After(logDurationFlag)
+		}
+
+		samples := 0
+	loop:
+		for {
+			select {
+			case <-stop:
+				break loop
+			case <-deadline:
+				break loop
+			case <-ticker.C:
+				if err := lw.write(newTelemetryRecord(s.frame())); err != nil {
+					return fmt.Errorf("error writing the log: %w", err)
+				}
+				samples++
+			}
+		}
+		if out != os.Stdout {
+			fmt.Fprintf(os.Stderr, "Logged %s to %s.\n", pluralize(samples, "sample"), logFileFlag)
+		}
+		return nil
+	},
+}
+
+// pluralize returns "1 sample", "2 samples".
+func pluralize(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return strconv.Itoa(n) + " " + noun + "s"
+}
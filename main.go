@@ -6,7 +6,6 @@ package main
 
 import (
 	"bytes"
-	"encoding/binary"
 	"fmt"
 	"io"
 	"log"
@@ -15,42 +14,85 @@ import (
 	"os/exec"
 	"os/user"
 	"path/filepath"
-	"runtime"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
+	"github.com/spf13/pflag"
 )
 
 // Version
 var version = "dev"
 
-// Mapping of voltage planes to indices.
-var planes = map[string]int{
-	"core":     0,
-	"gpu":      1,
-	"cache":    2,
-	"uncore":   3,
-	"analogio": 4,
-	// "digitalio": 5, // not working
+// Plane describes a voltage plane reachable through the 0x150 mailbox.
+type Plane struct {
+	Index       int    // plane index in the mailbox command
+	Name        string // flag name and profile key
+	Label       string // display name
+	Description string
+}
+
+// Voltage planes in canonical order. Everything that lists planes iterates this slice.
+var planes = []Plane{
+	{Index: 0, Name: "core", Label: "Core", Description: "CPU cores"},
+	{Index: 1, Name: "gpu", Label: "GPU", Description: "Integrated graphics"},
+	{Index: 2, Name: "cache", Label: "Cache", Description: "Ring bus and last level cache"},
+	{Index: 3, Name: "uncore", Label: "Uncore", Description: "System agent"},
+	{Index: 4, Name: "analogio", Label: "AnalogIO", Description: "Analog I/O"},
+	// {Index: 5, Name: "digitalio", ...}, // not working
+}
+
+// findPlane looks up a plane by name.
+func findPlane(name string) (Plane, bool) {
+	for _, p := range planes {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Plane{}, false
 }
 
 // MSR holds addresses of registers.
 type MSR struct {
-	addrVoltageOffsets uint64
-	addrUnits          uint64
-	addrPowerLimits    uint64
-	addrTemp           uint64
+	addrVoltageOffsets   uint64
+	addrUnits            uint64
+	addrPowerLimits      uint64
+	addrTemp             uint64
+	addrPlatformInfo     uint64
+	addrThermStatus      uint64 // per core
+	addrPkgThermStatus   uint64
+	addrPkgEnergy        uint64 // RAPL energy status registers
+	addrPP0Energy        uint64
+	addrPP1Energy        uint64
+	addrDRAMEnergy       uint64
+	addrMPERF            uint64 // per CPU, like APERF and the TSC
+	addrAPERF            uint64
+	addrTSC              uint64
+	addrPerfLimitReasons uint64
+	tccOffsetBits        uint // width of the TCC activation offset field in addrTemp
 }
 
 // Default addresses (for Core iX 6th–9th gen etc.)
 var ADDRESSES = MSR{
-	addrVoltageOffsets: 0x150,
-	addrUnits:          0x606,
-	addrPowerLimits:    0x610,
-	addrTemp:           0x1a2,
+	addrVoltageOffsets:   0x150,
+	addrUnits:            0x606,
+	addrPowerLimits:      0x610,
+	addrTemp:             0x1a2,
+	addrPlatformInfo:     0xce,
+	addrThermStatus:      0x19c,
+	addrPkgThermStatus:   0x1b1,
+	addrPkgEnergy:        0x611,
+	addrPP0Energy:        0x639,
+	addrPP1Energy:        0x641,
+	addrDRAMEnergy:       0x619,
+	addrMPERF:            0xe7,
+	addrAPERF:            0xe8,
+	addrTSC:              0x10,
+	addrPerfLimitReasons: 0x64f,
+	tccOffsetBits:        6, // bits 24-29; set from the CPU model at startup (see detectTCCOffsetBits)
 }
 
 // PowerLimit holds the power limit settings.
@@ -71,10 +113,14 @@ var (
 	dischargingSt = []byte("Discharging")
 )
 
-// isBatteryDischarging returns true if *any* battery in /sys/class/power_supply is discharging.
+// Root of the power_supply class directory; a variable so that a simulated
+// sysfs tree can stand in for it.
+var powerSupplyRoot = "/sys/class/power_supply"
+
+// isBatteryDischarging returns true if *any* battery in powerSupplyRoot is discharging.
 // Optimized to operate directly on byte slices to avoid heap string allocations.
 func isBatteryDischarging() bool {
-	base := "/sys/class/power_supply"
+	base := powerSupplyRoot
 	entries, err := os.ReadDir(base)
 	if err != nil {
 		return false
@@ -99,31 +145,43 @@ func isBatteryDischarging() bool {
 	return false
 }
 
-// ---------- MSR Read/Write Functions ----------
+// Power sources. They double as the names of the profiles used by 'profile apply auto'.
+const (
+	powerSourceAC      = "ac"
+	powerSourceBattery = "battery"
+)
 
-// validCPUs returns CPU indices with an available /dev/cpu/<i> directory.
-func validCPUs() ([]int, error) {
-	var cpus []int
-	n := runtime.NumCPU()
-	for i := 0; i < n; i++ {
-		path := fmt.Sprintf("/dev/cpu/%d", i)
-		if info, err := os.Stat(path); err == nil && info.IsDir() {
-			cpus = append(cpus, i)
-		}
+// currentPowerSource returns powerSourceBattery while discharging, powerSourceAC otherwise.
+func currentPowerSource() string {
+	if isBatteryDischarging() {
+		return powerSourceBattery
 	}
-	return cpus, nil
+	return powerSourceAC
 }
 
-// writeMSR writes an 8-byte little-endian value to the given address on all CPUs concurrently.
-func writeMSR(val uint64, addr uint64) error {
-	cpus, err := validCPUs()
+// resolveTempTarget picks the temperature target for the given power source from
+// the --temp (AC) and --temp-bat (battery) values. Values <= 0 mean "not set".
+// On battery without a battery target the AC target is used; the result is 0
+// when nothing should be applied.
+func resolveTempTarget(ac, bat int, source string) int {
+	if source == powerSourceBattery && bat > 0 {
+		return bat
+	}
+	if ac > 0 {
+		return ac
+	}
+	return 0
+}
+
+// ---------- MSR Read/Write Functions ----------
+
+// writeMSR writes a value to the given address on all CPUs concurrently.
+func writeMSR(dev MSRDevice, val uint64, addr uint64) error {
+	cpus, err := dev.CPUs()
 	if err != nil {
 		return err
 	}
 
-	buf := make([]byte, 8)
-	binary.LittleEndian.PutUint64(buf, val)
-
 	var wg sync.WaitGroup
 	errCh := make(chan error, len(cpus))
 
@@ -132,25 +190,10 @@ func writeMSR(val uint64, addr uint64) error {
 		wg.Add(1)
 		go func(cpu int) {
 			defer wg.Done()
-			path := fmt.Sprintf("/dev/cpu/%d/msr", cpu)
-			f, err := os.OpenFile(path, os.O_WRONLY, 0)
-			if err != nil {
-				if os.IsPermission(err) {
-					errCh <- fmt.Errorf("permission denied to %s (is Secure Boot / Kernel Lockdown enabled?)", path)
-					return
-				}
-				errCh <- err
-				return
-			}
-
-			// Use WriteAt to map to the 'pwrite' syscall directly, avoiding an extra 'lseek' syscall
-			_, err = f.WriteAt(buf, int64(addr))
-			f.Close() // Close immediately
-
-			if err != nil {
+			if err := dev.Write(cpu, addr, val); err != nil {
 				errCh <- err
 			} else {
-				log.Printf("Successfully wrote 0x%x to %s", val, path)
+				log.Printf("Successfully wrote 0x%x to MSR 0x%x on CPU %d", val, addr, cpu)
 			}
 		}(cpu)
 	}
@@ -167,25 +210,13 @@ func writeMSR(val uint64, addr uint64) error {
 	return nil
 }
 
-// readMSR reads an 8-byte little-endian value from the given address on the specified CPU.
-func readMSR(addr uint64, cpu int) (uint64, error) {
-	path := fmt.Sprintf("/dev/cpu/%d/msr", cpu)
-	f, err := os.OpenFile(path, os.O_RDONLY, 0)
+// readMSR reads a value from the given address on the specified CPU.
+func readMSR(dev MSRDevice, addr uint64, cpu int) (uint64, error) {
+	val, err := dev.Read(cpu, addr)
 	if err != nil {
-		if os.IsPermission(err) {
-			return 0, fmt.Errorf("permission denied to %s (is Secure Boot / Kernel Lockdown enabled?)", path)
-		}
-		return 0, err
-	}
-	defer f.Close()
-
-	buf := make([]byte, 8)
-	// Use ReadAt to map to the 'pread' syscall directly, avoiding an extra 'lseek' syscall
-	if _, err := f.ReadAt(buf, int64(addr)); err != nil {
 		return 0, err
 	}
-	val := binary.LittleEndian.Uint64(buf)
-	log.Printf("Read 0x%x from %s", val, path)
+	log.Printf("Read 0x%x from MSR 0x%x on CPU %d", val, addr, cpu)
 	return val, nil
 }
 
@@ -233,33 +264,128 @@ func unpackOffset(msrResponse uint64) float64 {
 	return unconvertOffset(value)
 }
 
-// readTemperature extracts the temperature target.
-func readTemperature(msr MSR) (int, error) {
-	val, err := readMSR(msr.addrTemp, 0)
+// TempTarget is the decoded temperature target register.
+type TempTarget struct {
+	TjMax  int // °C, bits 16-23
+	Offset int // TCC activation offset below TjMax (°C), bits 24 and up
+}
+
+// Target returns the effective throttling temperature in °C.
+func (t TempTarget) Target() int {
+	return t.TjMax - t.Offset
+}
+
+// Intel family 6 models known to implement the full 6-bit TCC activation offset
+// (bits 24-29): the Core and Xeon parts from Haswell on. Other models, such as the
+// Atom parts, may only implement bits 24-27.
+var tccOffset6BitModels = map[int]bool{
+	// Haswell
+	0x3c: true, 0x3f: true, 0x45: true, 0x46: true,
+	// Broadwell
+	0x3d: true, 0x47: true, 0x4f: true, 0x56: true,
+	// Skylake
+	0x4e: true, 0x5e: true, 0x55: true,
+	// Kaby Lake, Coffee Lake, Whiskey Lake, Amber Lake
+	0x8e: true, 0x9e: true,
+	// Comet Lake
+	0xa5: true, 0xa6: true,
+	// Cannon Lake, Ice Lake
+	0x66: true, 0x7d: true, 0x7e: true, 0x6a: true, 0x6c: true,
+	// Tiger Lake, Rocket Lake
+	0x8c: true, 0x8d: true, 0xa7: true,
+	// Alder Lake, Raptor Lake
+	0x97: true, 0x9a: true, 0xbe: true, 0xb7: true, 0xba: true, 0xbf: true,
+	// Meteor Lake, Lunar Lake, Arrow Lake
+	0xaa: true, 0xac: true, 0xbd: true, 0xc5: true, 0xc6: true,
+	// Sapphire Rapids, Emerald Rapids
+	0x8f: true, 0xcf: true,
+}
+
+// detectTCCOffsetBits returns the width of the TCC activation offset of the CPU
+// described by cpuinfo: 6 bits for the models known to implement them, else 4.
+func detectTCCOffsetBits(cpuinfo string) uint {
+	data, err := os.ReadFile(cpuinfo)
 	if err != nil {
-		return 0, err
+		return 4
+	}
+	var vendor string
+	family, model := -1, -1
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			if vendor != "" {
+				break // end of the first CPU
+			}
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "vendor_id":
+			vendor = value
+		case "cpu family":
+			family, _ = strconv.Atoi(value)
+		case "model":
+			model, _ = strconv.Atoi(value)
+		}
+	}
+	if vendor == "GenuineIntel" && family == 6 && tccOffset6BitModels[model] {
+		return 6
+	}
+	return 4
+}
+
+func tccOffsetMask(msr MSR) uint64 {
+	return (uint64(1)<<msr.tccOffsetBits - 1) << 24
+}
+
+// readTemperature extracts TjMax and the TCC offset.
+func readTemperature(dev MSRDevice, msr MSR) (TempTarget, error) {
+	val, err := readMSR(dev, msr.addrTemp, 0)
+	if err != nil {
+		return TempTarget{}, err
 	}
-	temp := int((val & (127 << 24)) >> 24)
-	return temp, nil
+	return TempTarget{
+		TjMax:  int((val >> 16) & 0xff),
+		Offset: int((val & tccOffsetMask(msr)) >> 24),
+	}, nil
 }
 
-// setTemperature sets a new temperature target (in °C).
-func setTemperature(temp int, msr MSR) error {
-	value := uint64((100 - temp) << 24)
-	return writeMSR(value, msr.addrTemp)
+// setTemperature sets a new temperature target (in °C) relative to the chip's TjMax.
+// Only the TCC offset field is modified; all other bits are written back unchanged.
+func setTemperature(dev MSRDevice, temp int, msr MSR) error {
+	val, err := readMSR(dev, msr.addrTemp, 0)
+	if err != nil {
+		return err
+	}
+	tjMax := int((val >> 16) & 0xff)
+	if tjMax == 0 {
+		return fmt.Errorf("cannot set temperature target: CPU does not report TjMax")
+	}
+	// Bit 30 of MSR_PLATFORM_INFO tells whether the TCC offset is programmable.
+	if info, err := readMSR(dev, msr.addrPlatformInfo, 0); err == nil && (info>>30)&1 == 0 {
+		return fmt.Errorf("cannot set temperature target: TCC offset is not programmable on this CPU")
+	}
+	maxOffset := 1<<msr.tccOffsetBits - 1
+	offset := tjMax - temp
+	if offset < 0 || offset > maxOffset {
+		return fmt.Errorf("temperature target %d°C out of range: supported %d-%d°C (TjMax %d°C)", temp, tjMax-maxOffset, tjMax, tjMax)
+	}
+	log.Printf("Setting temperature target to %d°C (TjMax %d°C, offset %d)", temp, tjMax, offset)
+	value := (val &^ tccOffsetMask(msr)) | uint64(offset)<<24
+	return writeMSR(dev, value, msr.addrTemp)
 }
 
 // readOffset sends a "read" command for the voltage offset and returns the measured value.
-func readOffset(plane string, msr MSR) (float64, error) {
-	planeIndex, ok := planes[plane]
+func readOffset(dev MSRDevice, plane string, msr MSR) (float64, error) {
+	p, ok := findPlane(plane)
 	if !ok {
 		return 0, fmt.Errorf("unknown plane: %s", plane)
 	}
-	valueToWrite := packOffset(planeIndex, 0, false)
-	if err := writeMSR(valueToWrite, msr.addrVoltageOffsets); err != nil {
+	valueToWrite := packOffset(p.Index, 0, false)
+	if err := writeMSR(dev, valueToWrite, msr.addrVoltageOffsets); err != nil {
 		return 0, err
 	}
-	val, err := readMSR(msr.addrVoltageOffsets, 0)
+	val, err := readMSR(dev, msr.addrVoltageOffsets, 0)
 	if err != nil {
 		return 0, err
 	}
@@ -267,8 +393,8 @@ func readOffset(plane string, msr MSR) (float64, error) {
 }
 
 // setOffset applies a new voltage offset (in mV) to a given plane.
-func setOffset(plane string, mV float64, msr MSR, force bool) error {
-	planeIndex, ok := planes[plane]
+func setOffset(dev MSRDevice, plane string, mV float64, msr MSR, force bool) error {
+	p, ok := findPlane(plane)
 	if !ok {
 		return fmt.Errorf("unknown plane: %s", plane)
 	}
@@ -277,13 +403,13 @@ func setOffset(plane string, mV float64, msr MSR, force bool) error {
 	}
 	log.Printf("Setting %s offset to %.2f mV", plane, mV)
 	target := convertOffset(mV)
-	writeValue := packOffset(planeIndex, target, true)
-	if err := writeMSR(writeValue, msr.addrVoltageOffsets); err != nil {
+	writeValue := packOffset(p.Index, target, true)
+	if err := writeMSR(dev, writeValue, msr.addrVoltageOffsets); err != nil {
 		return err
 	}
 	// Verify that the value was applied.
 	wantMV := unconvertOffset(target)
-	readMV, err := readOffset(plane, msr)
+	readMV, err := readOffset(dev, plane, msr)
 	if err != nil {
 		return err
 	}
@@ -299,16 +425,22 @@ func toSeconds(val uint64, unit float64) float64 {
 	return math.Pow(2, float64(val&0x1f)) * (1 + float64((val>>5)&0x3)/4.0) / unit
 }
 
-func readPowerLimit(msr MSR) (PowerLimit, error) {
+func readPowerLimit(dev MSRDevice, msr MSR) (PowerLimit, error) {
 	var pl PowerLimit
-	units, err := readMSR(msr.addrUnits, 0)
+	units, err := readMSR(dev, msr.addrUnits, 0)
 	if err != nil {
 		return pl, err
 	}
-	val, err := readMSR(msr.addrPowerLimits, 0)
+	val, err := readMSR(dev, msr.addrPowerLimits, 0)
 	if err != nil {
 		return pl, err
 	}
+	return decodePowerLimit(val, units), nil
+}
+
+// decodePowerLimit decodes a raw power limit register using the units register.
+func decodePowerLimit(val, units uint64) PowerLimit {
+	var pl PowerLimit
 	powerUnit := math.Pow(2, float64(units&0xf))
 	timeUnit := math.Pow(2, float64((units>>16)&0xf))
 	pl.ShortTermEnabled = ((val >> 47) & 0x1) != 0
@@ -319,7 +451,7 @@ func readPowerLimit(msr MSR) (PowerLimit, error) {
 	pl.LongTermTime = toSeconds(val>>17, timeUnit)
 	pl.Locked = ((val >> 63) & 1) != 0
 	pl.BackupRest = val & 0x7f010000ff010000
-	return pl, nil
+	return pl
 }
 
 func fromSeconds(val float64, unit float64) uint64 {
@@ -350,15 +482,15 @@ func fromSeconds(val float64, unit float64) uint64 {
 	return result
 }
 
-func setPowerLimit(pl PowerLimit, msr MSR) error {
-	oldPl, err := readPowerLimit(msr)
+func setPowerLimit(dev MSRDevice, pl PowerLimit, msr MSR) error {
+	oldPl, err := readPowerLimit(dev, msr)
 	if err != nil {
 		return err
 	}
 	if oldPl.Locked {
 		return fmt.Errorf("cannot write power limit because it is locked")
 	}
-	units, err := readMSR(msr.addrUnits, 0)
+	units, err := readMSR(dev, msr.addrUnits, 0)
 	if err != nil {
 		return err
 	}
@@ -415,10 +547,10 @@ func setPowerLimit(pl PowerLimit, msr MSR) error {
 		writeValue |= (1 << 63)
 	}
 
-	if err := writeMSR(writeValue, msr.addrPowerLimits); err != nil {
+	if err := writeMSR(dev, writeValue, msr.addrPowerLimits); err != nil {
 		return err
 	}
-	newVal, err := readMSR(msr.addrPowerLimits, 0)
+	newVal, err := readMSR(dev, msr.addrPowerLimits, 0)
 	if err != nil {
 		return err
 	}
@@ -430,6 +562,44 @@ func setPowerLimit(pl PowerLimit, msr MSR) error {
 
 // ---------- Utility Functions ----------
 
+// intel_pstate turbo switch (1 disables turbo, 0 enables it)
+const noTurboPath = "/sys/devices/system/cpu/intel_pstate/no_turbo"
+
+// setTurbo writes the no_turbo state (0 enables turbo, 1 disables it).
+func setTurbo(noTurbo int) error {
+	path := noTurboPath
+	if dryRunFlag {
+		fmt.Printf("[dry-run] sysfs %s <- %d (turbo %s)\n", path, noTurbo, boolToEnabled(noTurbo == 0))
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	state := strconv.Itoa(noTurbo)
+	if _, err := f.WriteString(state); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write to %s: %w", path, err)
+	}
+	f.Close()
+
+	if noTurbo == 0 {
+		fmt.Println("New Intel Turbo State ENABLED")
+	} else {
+		fmt.Println("New Intel Turbo State DISABLED")
+	}
+	return nil
+}
+
+// readTurbo returns the current no_turbo state.
+func readTurbo() (int, error) {
+	data, err := os.ReadFile(noTurboPath)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
 func boolToEnabled(b bool) string {
 	if b {
 		return "enabled"
@@ -442,13 +612,26 @@ func boolToEnabled(b bool) string {
 const persistConfigServiceName = "undervolt-go.service"
 const persistConfigServicePath = "/etc/systemd/system/" + persistConfigServiceName
 
+// persistUdevRule reruns the persistence service on power source changes, so
+// that the temperature target of the new power source is applied.
+const persistUdevRule = "/etc/udev/rules.d/99-undervolt-go-persist.rules"
+
+// systemctlPath finds systemctl, which is not in /usr/bin on every distro (e.g. NixOS).
+func systemctlPath() string {
+	path, err := exec.LookPath("systemctl")
+	if err != nil {
+		return "/usr/bin/systemctl"
+	}
+	return path
+}
+
 func runSystemctlCmd(name string, args ...string) {
 	cmd := exec.Command(name, args...)
 	_ = cmd.Run() // Ignore errors if already stopped/disabled
 }
 
-// enablePersistence creates and enables the systemd service based on current flags
-func enablePersistence() error {
+// enablePersistence creates and enables the systemd service running the binary with args
+func enablePersistence(args []string) error {
 	exePath, err := os.Executable()
 	if err != nil {
 		return fmt.Errorf("could not get executable path: %w", err)
@@ -458,10 +641,15 @@ func enablePersistence() error {
 		return fmt.Errorf("could not resolve executable path: %w", err)
 	}
 
-	// Reconstruct arguments, ignoring --persist
+	// Reconstruct arguments, ignoring --persist and --boot-guard
 	var execArgs []string
-	for _, arg := range os.Args[1:] {
-		if arg == "--persist" || arg == "-persist" {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--persist" || arg == "-persist" || arg == "--dry-run" || strings.HasPrefix(arg, "--boot-guard=") {
+			continue
+		}
+		if arg == "--boot-guard" {
+			i++ // skip the value
 			continue
 		}
 		// Wrap in quotes if it contains spaces
@@ -477,32 +665,214 @@ func enablePersistence() error {
 		execStart += " " + strings.Join(execArgs, " ")
 	}
 
+	// With the boot guard, the boot is marked before the settings are written, and
+	// the timer confirms them once they have been applied for bootGuardFlag. The
+	// timer is started also when applying failed, as the boot did not lock up.
+	guardPre, guardPost := "", ""
+	if bootGuardFlag > 0 {
+		guardPre = fmt.Sprintf("ExecStartPre=%s boot-guard begin\n", exePath)
+		guardPost = fmt.Sprintf("ExecStopPost=%s start --no-block %s\n", systemctlPath(), bootGuardTimerName)
+	}
+
 	serviceContent := fmt.Sprintf(`[Unit]
 Description=Apply Undervolt Go settings on boot and resume
 After=multi-user.target suspend.target hibernate.target hybrid-sleep.target suspend-then-hibernate.target
 
 [Service]
 Type=oneshot
-ExecStart=%s
-
+%sExecStart=%s
+%s
 [Install]
 WantedBy=multi-user.target suspend.target hibernate.target hybrid-sleep.target suspend-then-hibernate.target
-`, execStart)
+`, guardPre, execStart, guardPost)
+	guardService, guardTimer := bootGuardUnits(exePath, bootGuardFlag)
+
+	// Only a separate battery target differs between the power sources
+	ruleContent := ""
+	if p, err := profileFromArgs(execArgs); err == nil && p.TempBat != nil {
+		ruleContent = fmt.Sprintf(`SUBSYSTEM=="power_supply", ACTION=="change", RUN+="%s --no-block start %s"`+"\n",
+			systemctlPath(), persistConfigServiceName)
+	}
+
+	if dryRunFlag {
+		fmt.Printf("\n[dry-run] would create systemd service at %s:\n%s", persistConfigServicePath, serviceContent)
+		if bootGuardFlag > 0 {
+			fmt.Printf("[dry-run] would create systemd service at %s:\n%s", bootGuardServicePath, guardService)
+			fmt.Printf("[dry-run] would create systemd timer at %s:\n%s", bootGuardTimerPath, guardTimer)
+		}
+		if ruleContent != "" {
+			fmt.Printf("[dry-run] would create udev rule at %s:\n%s", persistUdevRule, ruleContent)
+		}
+		return nil
+	}
 
 	fmt.Printf("\nCreating systemd service at %s...\n", persistConfigServicePath)
 	if err := os.WriteFile(persistConfigServicePath, []byte(serviceContent), 0644); err != nil {
 		return fmt.Errorf("failed to write service file: %w", err)
 	}
+	if bootGuardFlag > 0 {
+		if err := os.WriteFile(bootGuardServicePath, []byte(guardService), 0644); err != nil {
+			return fmt.Errorf("failed to write boot guard service file: %w", err)
+		}
+		if err := os.WriteFile(bootGuardTimerPath, []byte(guardTimer), 0644); err != nil {
+			return fmt.Errorf("failed to write boot guard timer file: %w", err)
+		}
+	} else {
+		removeBootGuardUnits()
+	}
+	if ruleContent != "" {
+		if err := os.WriteFile(persistUdevRule, []byte(ruleContent), 0644); err != nil {
+			return fmt.Errorf("failed to write udev rule: %w", err)
+		}
+	} else if err := os.Remove(persistUdevRule); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Warning: could not remove %s: %v\n", persistUdevRule, err)
+	}
+	// New settings get a fresh start, also when the guard tripped on the previous ones
+	if err := newBootGuard().clear(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not clear the boot guard: %v\n", err)
+	}
 
 	runSystemctlCmd("systemctl", "daemon-reload")
 	runSystemctlCmd("systemctl", "enable", persistConfigServiceName)
+	runSystemctlCmd("udevadm", "control", "--reload-rules")
 
 	fmt.Println("Persistence enabled successfully. Settings will automatically apply on boot and wake.")
+	if ruleContent != "" {
+		fmt.Println("The temperature target of the new power source is applied when it changes.")
+	}
+	if bootGuardFlag > 0 {
+		fmt.Printf("Boot guard: if a boot does not stay up for %v after applying them, later boots skip them.\n", bootGuardFlag)
+	}
 	return nil
 }
 
+// removeBootGuardUnits stops and removes the boot guard timer and its service.
+func removeBootGuardUnits() {
+	runSystemctlCmd("systemctl", "stop", bootGuardTimerName)
+	for _, path := range []string{bootGuardTimerPath, bootGuardServicePath} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Warning: could not remove %s: %v\n", path, err)
+		}
+	}
+}
+
+// PersistenceStatus describes the boot/resume systemd service.
+type PersistenceStatus struct {
+	Enabled   bool     `json:"enabled" yaml:"enabled"`
+	ExecStart string   `json:"exec_start,omitempty" yaml:"exec_start,omitempty"`
+	Args      []string `json:"args,omitempty" yaml:"args,omitempty"`             // ExecStart split into arguments, without the binary
+	Settings  *Profile `json:"settings,omitempty" yaml:"settings,omitempty"`     // settings the arguments apply
+	BootGuard string   `json:"boot_guard,omitempty" yaml:"boot_guard,omitempty"` // state of the boot guard, when it has one
+}
+
+// readPersistenceStatus reports whether the service exists and which command it runs.
+// ExecStart is empty when the service file exists but could not be parsed.
+func readPersistenceStatus() PersistenceStatus {
+	var st PersistenceStatus
+	if _, err := os.Stat(persistConfigServicePath); err != nil {
+		return st
+	}
+	st.Enabled = true
+	st.BootGuard, _, _ = newBootGuard().status()
+	content, err := os.ReadFile(persistConfigServicePath)
+	if err != nil {
+		return st
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line) // Clean up potential whitespace
+		if strings.HasPrefix(line, "ExecStart=") {
+			st.ExecStart = strings.TrimPrefix(line, "ExecStart=")
+			break
+		}
+	}
+	if argv := splitExecStart(st.ExecStart); len(argv) > 0 {
+		st.Args = argv[1:]
+		if p, err := profileFromArgs(st.Args); err == nil {
+			st.Settings = p
+		}
+	}
+	return st
+}
+
+// splitExecStart splits an ExecStart line written by enablePersistence into its
+// arguments; arguments containing spaces are Go-quoted.
+func splitExecStart(line string) []string {
+	var argv []string
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		if quoted, err := strconv.QuotedPrefix(line); err == nil {
+			if arg, err := strconv.Unquote(quoted); err == nil {
+				argv = append(argv, arg)
+				line = line[len(quoted):]
+				continue
+			}
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		argv = append(argv, line[:end])
+		line = line[end:]
+	}
+	return argv
+}
+
+// profileFromArgs parses the setting flags in args, the inverse of Profile.args.
+// Other flags are ignored.
+func profileFromArgs(args []string) (*Profile, error) {
+	fs := pflag.NewFlagSet("persist", pflag.ContinueOnError)
+	fs.ParseErrorsWhitelist.UnknownFlags = true
+	offsets := make([]float64, len(planes))
+	for i, plane := range planes {
+		fs.Float64Var(&offsets[i], plane.Name, math.NaN(), "")
+	}
+	temp := fs.Int("temp", -1, "")
+	tempBat := fs.Int("temp-bat", -1, "")
+	turbo := fs.Int("turbo", -1, "")
+	p1 := fs.StringSlice("p1", nil, "")
+	p2 := fs.StringSlice("p2", nil, "")
+	lock := fs.Bool("lock-power-limit", false, "")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	p := &Profile{}
+	for i, plane := range planes {
+		if !math.IsNaN(offsets[i]) {
+			if p.Planes == nil {
+				p.Planes = map[string]float64{}
+			}
+			p.Planes[plane.Name] = offsets[i]
+		}
+	}
+	if *temp > 0 {
+		p.Temp = temp
+	}
+	if *tempBat > 0 {
+		p.TempBat = tempBat
+	}
+	if *turbo >= 0 {
+		enabled := *turbo == 0
+		p.Turbo = &enabled
+	}
+	var err error
+	if p.P1, err = powerLimitFromArgs("P1", *p1); err != nil {
+		return nil, err
+	}
+	if p.P2, err = powerLimitFromArgs("P2", *p2); err != nil {
+		return nil, err
+	}
+	if *lock {
+		p.Lock = lock
+	}
+	return p, nil
+}
+
 // disablePersistence removes the systemd service entirely
 func disablePersistence() error {
+	if dryRunFlag {
+		fmt.Printf("[dry-run] would remove systemd service %s\n", persistConfigServicePath)
+		return nil
+	}
 	fmt.Printf("Removing systemd service %s...\n", persistConfigServicePath)
 
 	runSystemctlCmd("systemctl", "stop", persistConfigServiceName)
@@ -511,6 +881,14 @@ func disablePersistence() error {
 	if err := os.Remove(persistConfigServicePath); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to remove service file: %w", err)
 	}
+	removeBootGuardUnits()
+	if err := os.Remove(persistUdevRule); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Warning: could not remove %s: %v\n", persistUdevRule, err)
+	}
+	runSystemctlCmd("udevadm", "control", "--reload-rules")
+	if err := newBootGuard().clear(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not clear the boot guard: %v\n", err)
+	}
 
 	runSystemctlCmd("systemctl", "daemon-reload")
 	runSystemctlCmd("systemctl", "reset-failed")
@@ -528,85 +906,99 @@ var (
 	tempFlag           int
 	tempBatFlag        int
 	turboFlag          int
-	coreOffset         float64
-	gpuOffset          float64
-	cacheOffset        float64
-	uncoreOffset       float64
-	analogioOffset     float64
+	planeOffsets       = make([]float64, len(planes)) // indexed like planes, NaN when unset
 	p1Args             []string
 	p2Args             []string
 	lockPowerLimit     bool
 	persistFlag        bool
 	disablePersistFlag bool
+	bootGuardFlag      time.Duration
+	simulateFlag       bool
+	dryRunFlag         bool
+	outputFlag         string
 )
 
-func applyFlags() error {
-	// Setup logging.
+// setupLogging enables debug logging with --verbose and silences it otherwise.
+func setupLogging() {
 	if verboseFlag {
 		log.SetFlags(log.LstdFlags | log.Lshortfile)
 	} else {
 		log.SetOutput(io.Discard)
 	}
+}
 
-	msr := ADDRESSES
+func applyFlags(dev MSRDevice) error {
+	setupLogging()
 
-	// Apply voltage offsets if provided.
-	if !math.IsNaN(coreOffset) {
-		if err := setOffset("core", coreOffset, msr, forceFlag); err != nil {
-			return err
-		}
+	if dryRunFlag {
+		fmt.Println("Dry run: printing register writes instead of performing them.")
 	}
-	if !math.IsNaN(gpuOffset) {
-		if err := setOffset("gpu", gpuOffset, msr, forceFlag); err != nil {
-			return err
-		}
+
+	if err := validateOutputFormat(outputFlag); err != nil {
+		return err
 	}
-	if !math.IsNaN(cacheOffset) {
-		if err := setOffset("cache", cacheOffset, msr, forceFlag); err != nil {
-			return err
+
+	msr := ADDRESSES
+
+	if flagsModifyHardware() {
+		// Record the change in the history, and the stock values before the
+		// first modification so that 'reset' can restore them.
+		before, record := trackChange(dev, msr)
+		if err := ensureSnapshot(before); err != nil {
+			return fmt.Errorf("could not save snapshot of current values: %w", err)
 		}
+		defer record()
 	}
-	if !math.IsNaN(uncoreOffset) {
-		if err := setOffset("uncore", uncoreOffset, msr, forceFlag); err != nil {
-			return err
+
+	if err := writeFlagSettings(dev, msr, currentPowerSource()); err != nil {
+		return err
+	}
+	if (tempFlag > 0 || tempBatFlag > 0) && recordsState() {
+		if err := saveTempTargets(tempFlag, tempBatFlag); err != nil {
+			log.Printf("Warning: could not record temperature targets: %v", err)
 		}
 	}
-	if !math.IsNaN(analogioOffset) {
-		if err := setOffset("analogio", analogioOffset, msr, forceFlag); err != nil {
+
+	// If --read is set, print current settings.
+	if readFlag {
+		report, err := readReport(dev, msr)
+		if err != nil {
 			return err
 		}
+		return writeReport(os.Stdout, report, outputFlag)
 	}
+	return nil
+}
 
-	// Set temperature targets if provided.
-	if tempFlag >= 0 && tempFlag != 0 {
-		if err := setTemperature(tempFlag, msr); err != nil {
+// writeFlagSettings writes the settings given by the flags to the hardware, taking
+// the temperature target of source. Unlike applyFlags it records nothing, for
+// callers that reapply settings recorded before (the daemon).
+func writeFlagSettings(dev MSRDevice, msr MSR, source string) error {
+	// Apply voltage offsets if provided.
+	for i, p := range planes {
+		if math.IsNaN(planeOffsets[i]) {
+			continue
+		}
+		if err := setOffset(dev, p.Name, planeOffsets[i], msr, forceFlag); err != nil {
 			return err
 		}
 	}
-	if tempBatFlag >= 0 && tempBatFlag != 0 {
-		if err := setTemperature(tempBatFlag, msr); err != nil {
-			return err
+
+	// Set the temperature target for the current power source if provided.
+	if tempFlag > 0 || tempBatFlag > 0 {
+		if temp := resolveTempTarget(tempFlag, tempBatFlag, source); temp > 0 {
+			if err := setTemperature(dev, temp, msr); err != nil {
+				return err
+			}
+		} else {
+			log.Printf("Not on battery, leaving temperature target unchanged")
 		}
 	}
 
 	// Set turbo state if provided.
 	if turboFlag >= 0 {
-		path := "/sys/devices/system/cpu/intel_pstate/no_turbo"
-		f, err := os.OpenFile(path, os.O_WRONLY, 0644)
-		if err != nil {
-			return fmt.Errorf("failed to open %s: %w", path, err)
-		}
-		state := strconv.Itoa(turboFlag)
-		if _, err := f.WriteString(state); err != nil {
-			f.Close()
-			return fmt.Errorf("failed to write to %s: %w", path, err)
-		}
-		f.Close()
-
-		if turboFlag == 0 {
-			fmt.Println("New Intel Turbo State ENABLED")
-		} else {
-			fmt.Println("New Intel Turbo State DISABLED")
+		if err := setTurbo(turboFlag); err != nil {
+			return err
 		}
 	}
 
@@ -645,86 +1037,33 @@ func applyFlags() error {
 	}
 
 	if len(p1Args) > 0 || len(p2Args) > 0 || lockPowerLimit {
-		if err := setPowerLimit(pl, msr); err != nil {
+		if err := setPowerLimit(dev, pl, msr); err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
-	// If --read is set, print current settings.
-	if readFlag {
-		temp, err := readTemperature(msr)
-		if err != nil {
-			return err
-		}
-		fmt.Printf("Current Settings:\n\n")
-		fmt.Printf("Temperature target: -%d (%d°C)\n", temp, 100-temp)
-		fmt.Printf("Voltage Offsets:\n")
-		for plane := range planes {
-			voltage, err := readOffset(plane, msr)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error reading %s offset: %v\n", plane, err)
-				continue
-			}
-			fmt.Printf("   %s: %.2f mV\n", plane, voltage)
-		}
-		// Read turbo state.
-		path := "/sys/devices/system/cpu/intel_pstate/no_turbo"
-		data, err := os.ReadFile(path)
-		if err == nil {
-			state := "enable"
-			if string(data) == "1\n" {
-				state = "disable"
-			}
-			fmt.Printf("Intel Turbo: %s\n", state)
-		}
-		// Read and print power limits.
-		plRead, err := readPowerLimit(msr)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error reading power limits:", err)
-		} else {
-			fmt.Printf("Power limit:\n   %.2fW [P2 (short): %.2fs - %s]\n   %.2fW [P1 (long): %.2fs - %s]%s\n",
-				plRead.ShortTermPower,
-				plRead.ShortTermTime,
-				boolToEnabled(plRead.ShortTermEnabled),
-				plRead.LongTermPower,
-				plRead.LongTermTime,
-				boolToEnabled(plRead.LongTermEnabled),
-				func() string {
-					if plRead.Locked {
-						return " [locked]"
-					}
-					return ""
-				}())
-		}
-
-		// Check persistence status
-		fmt.Printf("\nBoot/Resume Persistence Status:\n")
-		if _, err := os.Stat(persistConfigServicePath); err == nil {
-			fmt.Println("   Status: ENABLED (systemd service active)")
-			content, err := os.ReadFile(persistConfigServicePath)
-			foundCmd := false
-			if err == nil {
-				for _, line := range strings.Split(string(content), "\n") {
-					line = strings.TrimSpace(line) // Clean up potential whitespace
-					if strings.HasPrefix(line, "ExecStart=") {
-						fmt.Printf("   Active Command: %s\n", strings.TrimPrefix(line, "ExecStart="))
-						foundCmd = true
-						break
-					}
-				}
-			}
-
-			// Fallback in case the file exists but is malformed
-			if !foundCmd {
-				fmt.Println("   Active Command: [Service active, but ExecStart could not be parsed]")
-			}
-		} else {
-			fmt.Println("   Status: DISABLED")
+// loadMSRModule loads the msr kernel module unless the MSR device files already exist.
+func loadMSRModule() error {
+	matches, err := filepath.Glob("/dev/cpu/*/msr")
+	if err != nil || len(matches) == 0 {
+		if err := exec.Command("modprobe", "msr").Run(); err != nil {
+			return fmt.Errorf("failed to load msr module (is it enabled in your kernel?): %w", err)
 		}
 	}
 	return nil
 }
 
+// Commands declare what they need in their annotations under privilegesAnnotation;
+// commands without it need root and the MSRs.
+const (
+	privilegesAnnotation  = "privileges"
+	privilegesNone        = "none"         // reads the config or sysfs only
+	privilegesRoot        = "root"         // writes the config, but does not access the MSRs
+	privilegesMSROptional = "msr-optional" // uses the MSRs when running as root
+)
+
 var rootCmd = &cobra.Command{
 	Use:          rootCmdUseString,
 	Version:      version,
@@ -732,8 +1071,43 @@ var rootCmd = &cobra.Command{
 	Long:         "\nUndervolt Go\n\nA no-dependency utility to undervolt Intel CPUs on Linux systems.\n\nPlease use with extreme caution. It has the potential to damage your computer if used incorrectly.",
 	SilenceUsage: true, // Do not print usage when returning an execution error
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-		// Do not require root/MSR for help or list commands
-		if cmd.Name() == "help" || cmd.Name() == "list" || cmd.Name() == "save" {
+		// The simulated CPU implements the full offset field
+		if !simulateFlag {
+			ADDRESSES.tccOffsetBits = detectTCCOffsetBits(cpuinfoPath)
+		}
+
+		privileges := cmd.Annotations[privilegesAnnotation]
+		switch {
+		case cmd == profileSaveCmd && saveFromLiveFlag:
+			// Saving the live values reads the MSRs
+			privileges = ""
+		case cmd == profileDiffCmd && (len(args) < 2 || strings.ToLower(args[1]) == liveProfileName):
+			// Only comparing against live values reads the MSRs
+			privileges = ""
+		}
+		switch privileges {
+		case privilegesNone:
+			return nil
+		case privilegesMSROptional:
+			// Sensors are read from hwmon alone when the MSRs are not accessible
+			if os.Geteuid() == 0 && !simulateFlag {
+				loadMSRModule()
+			}
+			return nil
+		case privilegesRoot:
+			if os.Geteuid() != 0 {
+				return fmt.Errorf("you need to have root privileges. Rerun with sudo")
+			}
+			return nil
+		}
+
+		// The GUI may run as the user; it goes through the service or the pkexec helpers
+		if cmd == cmd.Root() && cmd.Flags().NFlag() == 0 && os.Geteuid() != 0 {
+			return nil
+		}
+
+		// The simulated MSR backend needs neither root nor the msr module
+		if simulateFlag {
 			return nil
 		}
 
@@ -741,13 +1115,7 @@ var rootCmd = &cobra.Command{
 			return fmt.Errorf("you need to have root privileges. Rerun with sudo")
 		}
 
-		matches, err := filepath.Glob("/dev/cpu/*/msr")
-		if err != nil || len(matches) == 0 {
-			if err := exec.Command("modprobe", "msr").Run(); err != nil {
-				return fmt.Errorf("failed to load msr module (is it enabled in your kernel?): %w", err)
-			}
-		}
-		return nil
+		return loadMSRModule()
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if cmd.Flags().NFlag() == 0 {
@@ -767,13 +1135,13 @@ var rootCmd = &cobra.Command{
 		}
 
 		// Apply the settings
-		if err := applyFlags(); err != nil {
+		if err := applyFlags(openMSRDevice()); err != nil {
 			return fmt.Errorf("failed to apply settings: %w", err)
 		}
 
 		// Handle --persist
 		if persistFlag {
-			if err := enablePersistence(); err != nil {
+			if err := enablePersistence(os.Args[1:]); err != nil {
 				return fmt.Errorf("error enabling persistence: %w", err)
 			}
 		}
@@ -782,11 +1150,9 @@ var rootCmd = &cobra.Command{
 }
 
 func init() {
-	// run before any command - load the config file
-	cobra.OnInitialize(initConfig)
-
 	// Basic undervolt flags.
 	rootCmd.PersistentFlags().BoolVar(&readFlag, "read", false, "Read existing values")
+	rootCmd.PersistentFlags().StringVar(&outputFlag, "output", outputText, "Output format for --read, profile show/diff and sensors (text, json, yaml)")
 	rootCmd.PersistentFlags().BoolVar(&verboseFlag, "verbose", false, "Print debug information")
 	rootCmd.PersistentFlags().BoolVar(&forceFlag, "force", false, "Allow setting positive offsets")
 	rootCmd.PersistentFlags().IntVar(&tempFlag, "temp", -1, "Set temperature target on AC (°C)")
@@ -794,11 +1160,9 @@ func init() {
 	rootCmd.PersistentFlags().IntVar(&turboFlag, "turbo", -1, "Set Intel Turbo (1 disabled, 0 enabled)")
 
 	// Voltage offset flags.
-	rootCmd.PersistentFlags().Float64Var(&coreOffset, "core", math.NaN(), "Core offset (mV)")
-	rootCmd.PersistentFlags().Float64Var(&gpuOffset, "gpu", math.NaN(), "GPU offset (mV)")
-	rootCmd.PersistentFlags().Float64Var(&cacheOffset, "cache", math.NaN(), "Cache offset (mV)")
-	rootCmd.PersistentFlags().Float64Var(&uncoreOffset, "uncore", math.NaN(), "Uncore offset (mV)")
-	rootCmd.PersistentFlags().Float64Var(&analogioOffset, "analogio", math.NaN(), "AnalogIO offset (mV)")
+	for i, p := range planes {
+		rootCmd.PersistentFlags().Float64Var(&planeOffsets[i], p.Name, math.NaN(), p.Label+" offset (mV)")
+	}
 
 	// Power limit flags as string slices for multi-value support.
 	rootCmd.PersistentFlags().StringSliceVar(&p1Args, "p1", []string{}, "P1 Power Limit (W) and Time Window (s), e.g., --p1=35,10")
@@ -808,9 +1172,52 @@ func init() {
 	// Systemd Persistence Flags
 	rootCmd.PersistentFlags().BoolVar(&persistFlag, "persist", false, "Create a systemd service to persist current settings")
 	rootCmd.PersistentFlags().BoolVar(&disablePersistFlag, "disable-persist", false, "Remove the persistence systemd service")
-
-	rootCmd.AddCommand(profileCmd)
-	profileCmd.AddCommand(profileSaveCmd, profileListCmd, profileApplyCmd, profileAutoCmd)
+	rootCmd.PersistentFlags().DurationVar(&bootGuardFlag, "boot-guard", 5*time.Minute, "With --persist, skip the settings on boot when the boot that last applied them did not stay up this long (0 disables)")
+
+	rootCmd.PersistentFlags().BoolVar(&dryRunFlag, "dry-run", false, "Print the register and sysfs writes instead of performing them")
+
+	// Run against an in-memory CPU instead of /dev/cpu/*/msr (for tests and CI)
+	rootCmd.PersistentFlags().BoolVar(&simulateFlag, "simulate", false, "Use a simulated MSR device instead of real hardware")
+	rootCmd.PersistentFlags().MarkHidden("simulate")
+
+	rootCmd.AddCommand(profileCmd, resetCmd, historyCmd, sensorsCmd, watchCmd, logCmd, exporterCmd, daemonCmd, serveCmd, dbusCmd, polkitHelperCmd, bootGuardCmd)
+	bootGuardCmd.AddCommand(bootGuardStatusCmd, bootGuardBeginCmd, bootGuardConfirmCmd, bootGuardClearCmd)
+	sensorsCmd.AddCommand(sensorsTempsCmd, sensorsFansCmd, sensorsPowerCmd, sensorsFreqCmd)
+	historyCmd.AddCommand(historyListCmd, historyUndoCmd)
+	resetCmd.Flags().BoolVar(&resetDisableAutoSwitch, "disable-auto-switch", false, "Also disable automatic profile switching")
+	profileCmd.AddCommand(profileSaveCmd, profileListCmd, profileShowCmd, profileDiffCmd, profileApplyCmd,
+		profileDeleteCmd, profileRenameCmd, profileCopyCmd, profileExportCmd, profileImportCmd, profileAutoCmd)
+	profileSaveCmd.Flags().BoolVar(&saveFromLiveFlag, "from-live", false, "Save the values currently active in hardware")
+	profileExportCmd.Flags().StringVarP(&exportFileFlag, "file", "f", "", "File to write the profile to (default standard output)")
+	profileImportCmd.Flags().StringVar(&importAsFlag, "as", "", "Name to import the profile under (default the exported name)")
+	profileImportCmd.Flags().BoolVar(&importOtherCPUFlag, "allow-other-cpu", false, "Import a profile tuned on another CPU model")
+	profileImportCmd.Flags().BoolVar(&importReplaceFlag, "replace", false, "Replace an existing profile of the same name")
+	profileAutoCmd.Flags().StringVar(&autoSwitchACFlag, "ac", "", "Profile to apply on AC power (default \"ac\")")
+	sensorsPowerCmd.Flags().DurationVar(&sensorsIntervalFlag, "interval", time.Second, "Time to measure the average power over")
+	daemonCmd.Flags().DurationVar(&daemonIntervalFlag, "interval", 10*time.Second, "Time between checks of the hardware")
+	exporterCmd.Flags().StringVar(&exporterListenFlag, "listen", "127.0.0.1:9877", "Address to serve /metrics on")
+	serveCmd.Flags().StringVar(&apiSocketFlag, "socket", apiSocketPath, "Path of the control socket")
+	serveCmd.Flags().StringVar(&apiGroupFlag, "group", apiGroup, "Group whose members may use the socket")
+	bootGuardConfirmCmd.Flags().DurationVar(&bootGuardAfterFlag, "after", 5*time.Minute, "Time the settings must have been applied for")
+	dbusCmd.Flags().BoolVar(&dbusSessionFlag, "session", false, "Use the session bus and skip polkit (for testing)")
+	logCmd.Flags().DurationVar(&logIntervalFlag, "interval", time.Second, "Time between samples, e.g. 500ms")
+	logCmd.Flags().DurationVar(&logDurationFlag, "duration", 0, "Stop after this time, e.g. 10m (default until interrupted)")
+	logCmd.Flags().StringVar(&logFormatFlag, "format", logFormatCSV, "Log format (csv, jsonl)")
+	logCmd.Flags().StringVarP(&logFileFlag, "file", "f", "", "File to write the log to (default standard output)")
+	watchCmd.Flags().DurationVar(&watchIntervalFlag, "interval", time.Second, "Time between refreshes, e.g. 500ms")
+	sensorsFreqCmd.Flags().DurationVar(&sensorsIntervalFlag, "interval", time.Second, "Time to measure the effective frequencies over")
+	profileAutoCmd.Flags().StringVar(&autoSwitchBatteryFlag, "battery", "", "Profile to apply on battery power (default \"battery\")")
+
+	// The help and completion commands cobra adds need nothing either
+	rootCmd.InitDefaultHelpCmd()
+	rootCmd.InitDefaultCompletionCmd()
+	for _, name := range []string{"help", "completion"} {
+		if c, _, err := rootCmd.Find([]string{name}); err == nil && c != rootCmd {
+			for _, c := range append(c.Commands(), c) {
+				c.Annotations = map[string]string{privilegesAnnotation: privilegesNone}
+			}
+		}
+	}
 }
 
 /* keep temporary : migration of configs to new location */
@@ -889,14 +1296,26 @@ func configDir() string {
 	return newConfigDir
 }
 
-// initConfig loads /etc/undervolt-go/config.yaml (if present)
-func initConfig() {
-	cfg := filepath.Join(configDir(), "config.yaml")
-	viper.SetConfigFile(cfg)
-	viper.SetConfigType("yaml")
-	if err := viper.ReadInConfig(); err != nil {
-		// no log output on boot when file is non-existent
+// saveTempTargets records the AC and battery temperature targets of the last apply,
+// so that --read can report both next to the currently active one.
+func saveTempTargets(ac, bat int) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
 	}
+	var targets TempTargetsConfig
+	if ac > 0 {
+		targets.AC = &ac
+	}
+	if bat > 0 {
+		targets.Battery = &bat
+	}
+	// Applies on boot, resume and power source changes repeat the same targets
+	if reflect.DeepEqual(cfg.TempTargets, targets) {
+		return nil
+	}
+	cfg.TempTargets = targets
+	return cfg.save()
 }
 
 // profile subcommand
@@ -906,108 +1325,212 @@ var profileCmd = &cobra.Command{
 }
 
 // save profile subcommand to profile subcommand
+var saveFromLiveFlag bool
+
 var profileSaveCmd = &cobra.Command{
-	Use:   "save [ac|battery]",
-	Short: "Save current flags as a profile",
-	Args:  cobra.ExactArgs(1),
+	Use:         "save <name>",
+	Annotations: map[string]string{privilegesAnnotation: privilegesRoot},
+	Short:       "Save current flags, or with --from-live the values active in hardware, as a profile",
+	Long: "Save current flags as a profile.\n\n" +
+		"With --from-live the voltage offsets, power limits, temperature target and turbo state are read from the hardware instead. " +
+		"The temperature target is saved for the current power source (--temp on AC, --temp-bat on battery). " +
+		"Values that cannot be read are left out and listed in the profile notes.",
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		name := args[0]
-		base := "profiles." + name + "."
-		viper.Set(base+"planes.core", coreOffset)
-		viper.Set(base+"planes.gpu", gpuOffset)
-		viper.Set(base+"planes.cache", cacheOffset)
-		viper.Set(base+"planes.uncore", uncoreOffset)
-		viper.Set(base+"planes.analogio", analogioOffset)
-		viper.Set(base+"tl.temp", tempFlag)
-		viper.Set(base+"tl.temp-bat", tempBatFlag)
-		viper.Set(base+"turbo", turboFlag)
-		// Only save P1 if exactly two args were provided
-		if len(p1Args) == 2 {
-			p1_0, err1 := strToFloat64(p1Args[0])
-			p1_1, err2 := strToFloat64(p1Args[1])
-			if err1 != nil || err2 != nil {
-				return fmt.Errorf("invalid numeric args for P1")
+		name := strings.ToLower(args[0])
+		if err := checkProfileName(name); err != nil {
+			return err
+		}
+		var p *Profile
+		if saveFromLiveFlag {
+			if flagsModifyHardware() {
+				return fmt.Errorf("--from-live cannot be combined with flags setting values")
 			}
-			viper.Set(base+"pl.p1", []float64{p1_0, p1_1})
-		}
-		// Only save P2 if exactly two args were provided
-		if len(p2Args) == 2 {
-			p2_0, err1 := strToFloat64(p2Args[0])
-			p2_1, err2 := strToFloat64(p2Args[1])
-			if err1 != nil || err2 != nil {
-				return fmt.Errorf("invalid numeric args for P2")
+			setupLogging()
+			p = liveProfileForSave(openMSRDevice(), ADDRESSES)
+			for _, note := range p.Notes {
+				fmt.Fprintf(os.Stderr, "Warning: %s\n", note)
+			}
+		} else {
+			var err error
+			if p, err = profileFromFlags(); err != nil {
+				return err
 			}
-			viper.Set(base+"pl.p2", []float64{p2_0, p2_1})
 		}
-
-		if err := os.MkdirAll(filepath.Join(configDir()), 0755); err != nil {
+		cfg, err := loadConfig()
+		if err != nil {
 			return err
 		}
-		if err := viper.WriteConfigAs(filepath.Join(configDir(), "config.yaml")); err != nil {
+		cfg.Profiles[name] = p
+		if err := cfg.save(); err != nil {
 			return fmt.Errorf("error saving config: %w", err)
 		}
-		fmt.Println("Profile ", name, " saved.")
+		fmt.Printf("Profile '%s' saved.\n", name)
 		return nil
 	},
 }
 
 // list profiles subcommand to profile subcommand
 var profileListCmd = &cobra.Command{
-	Use:   "list",
-	Short: "List available profiles",
-	Run: func(cmd *cobra.Command, args []string) {
-		for name := range viper.GetStringMap("profiles") {
-			fmt.Println(" -", name)
+	Use:         "list",
+	Annotations: map[string]string{privilegesAnnotation: privilegesNone},
+	Short:       "List available profiles",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		for _, name := range cfg.profileNames() {
+			if sources := cfg.autoSwitchSources(name); len(sources) > 0 {
+				fmt.Printf(" - %s (auto-switch: %s)\n", name, strings.Join(sources, ", "))
+			} else {
+				fmt.Println(" -", name)
+			}
+		}
+		return nil
+	},
+}
+
+// show profile subcommand to profile subcommand
+var profileShowCmd = &cobra.Command{
+	Use:         "show <name>",
+	Annotations: map[string]string{privilegesAnnotation: privilegesNone},
+	Short:       "Show the settings stored in a profile",
+	Args:        cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateOutputFormat(outputFlag); err != nil {
+			return err
+		}
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		name := strings.ToLower(args[0])
+		p, err := cfg.profile(name)
+		if err != nil {
+			return err
+		}
+		if outputFlag != outputText {
+			return encodeOutput(os.Stdout, p, outputFlag)
+		}
+		fmt.Printf("Profile: %s\n", name)
+		if sources := cfg.autoSwitchSources(name); len(sources) > 0 {
+			fmt.Printf("Auto-switch: %s\n", strings.Join(sources, ", "))
+		}
+		writeProfileText(os.Stdout, p)
+		return nil
+	},
+}
+
+// delete profile subcommand to profile subcommand
+var profileDeleteCmd = &cobra.Command{
+	Use:         "delete <name>",
+	Annotations: map[string]string{privilegesAnnotation: privilegesRoot},
+	Short:       "Delete a profile",
+	Args:        cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := strings.ToLower(args[0])
+		if err := deleteProfile(name); err != nil {
+			return err
+		}
+		fmt.Printf("Profile '%s' deleted.\n", name)
+		return nil
+	},
+}
+
+// deleteProfile removes a profile from the config, unless auto-switch applies it.
+func deleteProfile(name string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if _, err := cfg.profile(name); err != nil {
+		return err
+	}
+	if sources := cfg.autoSwitchSources(name); len(sources) > 0 && isAutoSwitchEnabled() {
+		return fmt.Errorf("profile '%s' is used by auto-switch on %s; map another profile with 'profile auto-switch enable' or disable auto-switch first",
+			name, strings.Join(sources, " and "))
+	}
+	cfg.removeProfile(name)
+	if err := cfg.save(); err != nil {
+		return fmt.Errorf("error saving config: %w", err)
+	}
+	return nil
+}
+
+// rename profile subcommand to profile subcommand
+var profileRenameCmd = &cobra.Command{
+	Use:         "rename <old> <new>",
+	Annotations: map[string]string{privilegesAnnotation: privilegesRoot},
+	Short:       "Rename a profile",
+	Args:        cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		src, dst := strings.ToLower(args[0]), strings.ToLower(args[1])
+		if err := cfg.copyProfile(src, dst); err != nil {
+			return err
 		}
+		sources := cfg.autoSwitchSources(src)
+		cfg.removeProfile(src)
+		// Keep auto-switch pointing at the renamed profile
+		for _, source := range sources {
+			cfg.setAutoSwitchProfile(source, dst)
+		}
+		if err := cfg.save(); err != nil {
+			return fmt.Errorf("error saving config: %w", err)
+		}
+		fmt.Printf("Profile '%s' renamed to '%s'.\n", src, dst)
+		return nil
+	},
+}
+
+// copy profile subcommand to profile subcommand
+var profileCopyCmd = &cobra.Command{
+	Use:         "copy <source> <destination>",
+	Annotations: map[string]string{privilegesAnnotation: privilegesRoot},
+	Short:       "Copy a profile under a new name",
+	Args:        cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		src, dst := strings.ToLower(args[0]), strings.ToLower(args[1])
+		if err := cfg.copyProfile(src, dst); err != nil {
+			return err
+		}
+		if err := cfg.save(); err != nil {
+			return fmt.Errorf("error saving config: %w", err)
+		}
+		fmt.Printf("Profile '%s' copied to '%s'.\n", src, dst)
+		return nil
 	},
 }
 
 // apply profile subcommand to profile subcommand
 var profileApplyCmd = &cobra.Command{
-	Use:   "apply [auto|ac|battery]",
-	Short: "Apply given profile",
+	Use:   "apply <name|auto>",
+	Short: "Apply given profile, or with 'auto' the profile mapped to the current power source",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		name := args[0]
-		if name == "auto" {
-			if isBatteryDischarging() {
-				name = "battery"
-			} else {
-				name = "ac"
-			}
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
 		}
-		key := "profiles." + name
-		if !viper.IsSet(key) {
-			return fmt.Errorf("profile '%s' not found", name)
-		}
-		// Get values from profile and set the variables
-		p := viper.Sub(key)
-		coreOffset = p.GetFloat64("planes.core")
-		gpuOffset = p.GetFloat64("planes.gpu")
-		cacheOffset = p.GetFloat64("planes.cache")
-		uncoreOffset = p.GetFloat64("planes.uncore")
-		analogioOffset = p.GetFloat64("planes.analogio")
-		tempFlag = p.GetInt("tl.temp")
-		tempBatFlag = p.GetInt("tl.temp-bat")
-		turboFlag = p.GetInt("turbo")
-		/*
-		 *			we can actually do. the only problem is that the values are ints and the flags are strings
-		 *			p1Args := p.GetIntSlice("pl.p1")
-		 *			p2Args := p.GetIntSlice("pl.p2")
-		 */
-		// power‑limit slices:
-		if raw := p.Get("pl.p1"); raw != nil {
-			if arr, ok := raw.([]any); ok && len(arr) == 2 {
-				p1Args = []string{fmt.Sprint(arr[0]), fmt.Sprint(arr[1])}
-			}
+		name := strings.ToLower(args[0])
+		if name == "auto" {
+			name = cfg.autoSwitchProfile(currentPowerSource())
 		}
-		if raw := p.Get("pl.p2"); raw != nil {
-			if arr, ok := raw.([]any); ok && len(arr) == 2 {
-				p2Args = []string{fmt.Sprint(arr[0]), fmt.Sprint(arr[1])}
-			}
+		p, err := cfg.profile(name)
+		if err != nil {
+			return err
 		}
-		// Apply the settings
-		if err := applyFlags(); err != nil {
+		// Set the flag variables from the profile and apply them
+		p.setFlags()
+		if err := applyFlags(openMSRDevice()); err != nil {
 			return fmt.Errorf("failed to apply settings: %w", err)
 		}
 		return nil
@@ -1018,17 +1541,47 @@ var profileApplyCmd = &cobra.Command{
 const autoUdevRule = "/etc/udev/rules.d/99-undervolt-go-auto.rules"
 const autoServicePath = "/etc/systemd/system/undervolt-go-auto.service"
 
-var profileAutoCmd = &cobra.Command{
-	Use:   "auto-switch [enable|disable]",
-	Short: "Enable or disable automatic profile switching on AC/Battery events",
-	Args:  cobra.ExactArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) error {
-		action := args[0]
-		if action == "enable" {
-			exePath, _ := os.Executable()
-			exePath, _ = filepath.EvalSymlinks(exePath)
+// isAutoSwitchEnabled reports whether the auto-switch udev rule is installed
+func isAutoSwitchEnabled() bool {
+	_, err := os.Stat(autoUdevRule)
+	return err == nil
+}
+
+// disableAutoSwitch removes the auto-switch service and udev rule
+func disableAutoSwitch() {
+	os.Remove(autoServicePath)
+	os.Remove(autoUdevRule)
+	exec.Command("systemctl", "daemon-reload").Run()
+	exec.Command("udevadm", "control", "--reload-rules").Run()
+	fmt.Println("Auto-switch disabled.")
+}
+
+// enableAutoSwitch installs the auto-switch service and udev rule. Non-empty ac
+// and battery map those profiles to the power sources first.
+func enableAutoSwitch(ac, battery string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	mapping := map[string]string{powerSourceAC: ac, powerSourceBattery: battery}
+	for _, source := range []string{powerSourceAC, powerSourceBattery} {
+		if name := strings.ToLower(mapping[source]); name != "" {
+			cfg.setAutoSwitchProfile(source, name)
+		}
+		if name := cfg.autoSwitchProfile(source); cfg.Profiles[name] == nil {
+			return fmt.Errorf("profile '%s' used on %s does not exist; save it first or pick another with --%s", name, source, source)
+		}
+	}
+	if ac != "" || battery != "" {
+		if err := cfg.save(); err != nil {
+			return fmt.Errorf("error saving config: %w", err)
+		}
+	}
+
+	exePath, _ := os.Executable()
+	exePath, _ = filepath.EvalSymlinks(exePath)
 
-			serviceContent := fmt.Sprintf(`[Unit]
+	serviceContent := fmt.Sprintf(`[Unit]
 Description=Apply Undervolt Go Auto Profile
 After=multi-user.target
 
@@ -1037,32 +1590,39 @@ Type=oneshot
 ExecStart=%s profile apply auto
 `, exePath)
 
-			if err := os.WriteFile(autoServicePath, []byte(serviceContent), 0644); err != nil {
-				return fmt.Errorf("failed to create service: %w", err)
-			}
+	if err := os.WriteFile(autoServicePath, []byte(serviceContent), 0644); err != nil {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
 
-			// Dynamically find systemctl path to account for different Linux distros (e.g. NixOS)
-			systemctlPath, err := exec.LookPath("systemctl")
-			if err != nil {
-				systemctlPath = "/usr/bin/systemctl"
-			}
+	// --no-block is important so udev doesn't hang waiting for the command
+	ruleContent := fmt.Sprintf(`SUBSYSTEM=="power_supply", ACTION=="change", RUN+="%s --no-block start undervolt-go-auto.service"`+"\n", systemctlPath())
+	if err := os.WriteFile(autoUdevRule, []byte(ruleContent), 0644); err != nil {
+		return fmt.Errorf("failed to create udev rule: %w", err)
+	}
 
-			// --no-block is important so udev doesn't hang waiting for the command
-			ruleContent := fmt.Sprintf(`SUBSYSTEM=="power_supply", ACTION=="change", RUN+="%s --no-block start undervolt-go-auto.service"`+"\n", systemctlPath)
-			if err := os.WriteFile(autoUdevRule, []byte(ruleContent), 0644); err != nil {
-				return fmt.Errorf("failed to create udev rule: %w", err)
-			}
+	exec.Command("systemctl", "daemon-reload").Run()
+	exec.Command("udevadm", "control", "--reload-rules").Run()
+	fmt.Printf("Auto-switch enabled (AC: %s, battery: %s).\n", cfg.autoSwitchProfile(powerSourceAC), cfg.autoSwitchProfile(powerSourceBattery))
+	return nil
+}
 
-			exec.Command("systemctl", "daemon-reload").Run()
-			exec.Command("udevadm", "control", "--reload-rules").Run()
-			fmt.Println("Auto-switch enabled.")
+var autoSwitchACFlag, autoSwitchBatteryFlag string
 
+var profileAutoCmd = &cobra.Command{
+	Use:   "auto-switch [enable|disable]",
+	Short: "Enable or disable automatic profile switching on AC/Battery events",
+	Long: "Enable or disable automatic profile switching on AC/Battery events.\n\n" +
+		"By default the profiles named 'ac' and 'battery' are applied. Use --ac and --battery with 'enable' to pick other profiles.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		action := args[0]
+		if action == "enable" {
+			return enableAutoSwitch(autoSwitchACFlag, autoSwitchBatteryFlag)
 		} else if action == "disable" {
-			os.Remove(autoServicePath)
-			os.Remove(autoUdevRule)
-			exec.Command("systemctl", "daemon-reload").Run()
-			exec.Command("udevadm", "control", "--reload-rules").Run()
-			fmt.Println("Auto-switch disabled.")
+			if cmd.Flags().Changed("ac") || cmd.Flags().Changed("battery") {
+				return fmt.Errorf("--ac and --battery can only be used with 'enable'")
+			}
+			disableAutoSwitch()
 		} else {
 			return fmt.Errorf("invalid argument. Use 'enable' or 'disable'")
 		}
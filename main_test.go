@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func TestPersistenceArgsRoundTrip(t *testing.T) {
+	temp, turbo, lock := 85, false, true
+	want := &Profile{
+		Planes: map[string]float64{"core": -80, "cache": -50.5},
+		Temp:   &temp,
+		Turbo:  &turbo,
+		P1:     &PowerLimitSetting{PowerW: 35, TimeS: 28},
+		Lock:   &lock,
+	}
+	line := "/usr/local/bin/undervolt-go " + strings.Join(want.args(), " ") + ` --verbose "--some thing"`
+	argv := splitExecStart(line)
+	if argv[0] != "/usr/local/bin/undervolt-go" || argv[len(argv)-1] != "--some thing" {
+		t.Fatalf("splitExecStart(%q) = %q", line, argv)
+	}
+	got, err := profileFromArgs(argv[1:])
+	if err != nil {
+		t.Fatalf("profileFromArgs: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("profileFromArgs(%q) = %+v, want %+v", argv[1:], got, want)
+	}
+}
+
+func TestDetectTCCOffsetBits(t *testing.T) {
+	tests := []struct {
+		name, cpuinfo string
+		want          uint
+	}{
+		{"kaby lake", "vendor_id\t: GenuineIntel\ncpu family\t: 6\nmodel\t\t: 142\n\nvendor_id\t: GenuineIntel\n", 6},
+		{"goldmont atom", "vendor_id\t: GenuineIntel\ncpu family\t: 6\nmodel\t\t: 92\n", 4},
+		{"amd", "vendor_id\t: AuthenticAMD\ncpu family\t: 25\nmodel\t\t: 80\n", 4},
+		{"missing", "", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cpuinfo")
+			if tt.cpuinfo != "" {
+				if err := os.WriteFile(path, []byte(tt.cpuinfo), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := detectTCCOffsetBits(path); got != tt.want {
+				t.Errorf("detectTCCOffsetBits = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetTemperatureOffsetWidth(t *testing.T) {
+	for _, tt := range []struct {
+		bits    uint
+		temp    int
+		wantErr bool
+	}{{6, 80, false}, {4, 85, false}, {4, 80, true}} {
+		msr := ADDRESSES
+		msr.tccOffsetBits = tt.bits
+		dev := newSimMSR(1) // TjMax 100 °C
+		err := setTemperature(dev, tt.temp, msr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%d bits, %d°C: error = %v, want error %v", tt.bits, tt.temp, err, tt.wantErr)
+			continue
+		}
+		if err == nil {
+			if got, _ := readTemperature(dev, msr); got.Target() != tt.temp {
+				t.Errorf("%d bits: target = %d°C, want %d°C", tt.bits, got.Target(), tt.temp)
+			}
+		}
+	}
+}
+
+// A subcommand flag of the same name hides the root flag, giving one name two meanings.
+func TestNoShadowedRootFlags(t *testing.T) {
+	root := rootCmd.PersistentFlags()
+	var walk func(c *cobra.Command)
+	walk = func(c *cobra.Command) {
+		c.LocalNonPersistentFlags().VisitAll(func(f *pflag.Flag) {
+			if root.Lookup(f.Name) != nil || (f.Shorthand != "" && root.ShorthandLookup(f.Shorthand) != nil) {
+				t.Errorf("%s: --%s shadows a root flag", c.CommandPath(), f.Name)
+			}
+		})
+		for _, sub := range c.Commands() {
+			walk(sub)
+		}
+	}
+	for _, c := range rootCmd.Commands() {
+		walk(c)
+	}
+}
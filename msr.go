@@ -0,0 +1,363 @@
+// msr.go
+// MSR device backends: the real /dev/cpu/<n>/msr interface and an in-memory simulation.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// MSRDevice provides per-CPU access to model-specific registers.
+// Everything that touches MSRs takes one, so the apply path can run against
+// real hardware or against simMSR on machines without root or Intel silicon.
+type MSRDevice interface {
+	// CPUs returns the indices of the CPUs whose MSRs can be accessed.
+	CPUs() ([]int, error)
+	// Read returns the 64-bit value of register addr on the given CPU.
+	Read(cpu int, addr uint64) (uint64, error)
+	// Write stores val into register addr on the given CPU.
+	Write(cpu int, addr uint64, val uint64) error
+}
+
+// openMSRDevice returns the MSR backend selected by the command line flags.
+func openMSRDevice() MSRDevice {
+	var dev MSRDevice = hwMSR{}
+	if simulateFlag {
+		dev = newSimMSR(runtime.NumCPU())
+	}
+	if dryRunFlag {
+		dev = newDryRunMSR(dev, os.Stdout)
+	}
+	return dev
+}
+
+// ---------- Hardware Backend ----------
+
+// hwMSR accesses MSRs through the kernel msr driver (/dev/cpu/<n>/msr).
+type hwMSR struct{}
+
+func (hwMSR) path(cpu int) string {
+	return fmt.Sprintf("/dev/cpu/%d/msr", cpu)
+}
+
+// CPUs returns CPU indices with an available /dev/cpu/<i> directory.
+func (hwMSR) CPUs() ([]int, error) {
+	var cpus []int
+	n := runtime.NumCPU()
+	for i := 0; i < n; i++ {
+		path := fmt.Sprintf("/dev/cpu/%d", i)
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			cpus = append(cpus, i)
+		}
+	}
+	return cpus, nil
+}
+
+func (d hwMSR) Read(cpu int, addr uint64) (uint64, error) {
+	path := d.path(cpu)
+	f, err := os.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		if os.IsPermission(err) {
+			return 0, fmt.Errorf("permission denied to %s (is Secure Boot / Kernel Lockdown enabled?)", path)
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 8)
+	// Use ReadAt to map to the 'pread' syscall directly, avoiding an extra 'lseek' syscall
+	if _, err := f.ReadAt(buf, int64(addr)); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+func (d hwMSR) Write(cpu int, addr uint64, val uint64) error {
+	path := d.path(cpu)
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		if os.IsPermission(err) {
+			return fmt.Errorf("permission denied to %s (is Secure Boot / Kernel Lockdown enabled?)", path)
+		}
+		return err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, val)
+	// Use WriteAt to map to the 'pwrite' syscall directly, avoiding an extra 'lseek' syscall
+	_, err = f.WriteAt(buf, int64(addr))
+	return err
+}
+
+// ---------- Simulated Backend ----------
+
+// simMSR is an in-memory CPU that models the registers used by undervolt-go.
+// All modelled registers are package-scoped, so every CPU shares one state.
+//
+//   - 0x150 is a mailbox: writing a command stores or selects a plane offset,
+//     and reading returns the offset of the plane selected by the last command.
+//   - 0x606 holds fixed RAPL units and is read-only.
+//   - 0x610 holds the package power limits; writes fail once the lock bit is set.
+//   - 0x1a2 holds the temperature target; the TjMax field is read-only.
+//   - 0xce (platform info) is read-only and reports a programmable TCC offset.
+//   - 0x19c and 0x1b1 (core and package thermal status) report fixed readings,
+//     and 0x64f (perf limit reasons) reports PL2 throttling in the past.
+//   - 0x611, 0x639, 0x641 and 0x619 (RAPL energy status) count up at a constant
+//     power per domain, starting close to the 32-bit wraparound.
+//   - 0x10, 0xe7 and 0xe8 (TSC, MPERF, APERF) count up for a 2.4 GHz CPU that
+//     is busy a quarter of the time at 3.6 GHz.
+type simMSR struct {
+	mu      sync.Mutex
+	ncpu    int
+	offsets map[int]uint32 // plane index -> raw offset (bits 21-31)
+	mailbox uint64
+	regs    map[uint64]uint64
+	start   time.Time // when the energy counters started counting
+}
+
+// Simulated power draw of the RAPL domains, in watts.
+var simEnergyWatts = map[uint64]float64{
+	ADDRESSES.addrPkgEnergy:  12,
+	ADDRESSES.addrPP0Energy:  8,
+	ADDRESSES.addrPP1Energy:  0.5,
+	ADDRESSES.addrDRAMEnergy: 1.5,
+}
+
+// Simulated rates of the frequency counters, in counts per second.
+var simCounterRates = map[uint64]float64{
+	ADDRESSES.addrTSC:   2.4e9,
+	ADDRESSES.addrMPERF: 0.6e9,
+	ADDRESSES.addrAPERF: 0.9e9,
+}
+
+// newSimMSR returns a simulated CPU with ncpu logical CPUs and stock register values.
+func newSimMSR(ncpu int) *simMSR {
+	if ncpu < 1 {
+		ncpu = 1
+	}
+	return &simMSR{
+		ncpu:    ncpu,
+		start:   time.Now(),
+		offsets: make(map[int]uint32),
+		regs: map[uint64]uint64{
+			ADDRESSES.addrUnits: 0x000a0e03, // 1/8 W, 61 µJ, 976 µs
+			// PL1 45 W / 28 s and PL2 44 W / 2.44 ms, both enabled, unlocked.
+			ADDRESSES.addrPowerLimits:  0x0042816000dc8168,
+			ADDRESSES.addrTemp:         0x00640000, // TjMax 100 °C, no offset
+			ADDRESSES.addrPlatformInfo: 1 << 30,    // programmable TCC offset
+			// Digital readouts of 57 and 55 °C below TjMax (43 and 45 °C); both readings are
+			// valid and the core has logged power limiting.
+			ADDRESSES.addrThermStatus:      1<<31 | 57<<16 | 1<<11,
+			ADDRESSES.addrPkgThermStatus:   1<<31 | 55<<16,
+			ADDRESSES.addrPerfLimitReasons: 1 << 27, // PL2 log
+		},
+	}
+}
+
+func (s *simMSR) CPUs() ([]int, error) {
+	cpus := make([]int, s.ncpu)
+	for i := range cpus {
+		cpus[i] = i
+	}
+	return cpus, nil
+}
+
+func (s *simMSR) checkCPU(cpu int) error {
+	if cpu < 0 || cpu >= s.ncpu {
+		return fmt.Errorf("simulated cpu %d does not exist", cpu)
+	}
+	return nil
+}
+
+func (s *simMSR) Read(cpu int, addr uint64) (uint64, error) {
+	if err := s.checkCPU(cpu); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if addr == ADDRESSES.addrVoltageOffsets {
+		return s.mailbox, nil
+	}
+	if watts, ok := simEnergyWatts[addr]; ok {
+		// Energy in units of 2^-ESU J (ESU from 0x606), wrapping at 32 bits.
+		esu := (s.regs[ADDRESSES.addrUnits] >> 8) & 0x1f
+		counts := time.Since(s.start).Seconds() * watts * math.Pow(2, float64(esu))
+		return (0xffff0000 + uint64(counts)) & 0xffffffff, nil
+	}
+	if rate, ok := simCounterRates[addr]; ok {
+		return uint64(time.Since(s.start).Seconds() * rate), nil
+	}
+	val, ok := s.regs[addr]
+	if !ok {
+		return 0, fmt.Errorf("simulated read of unsupported MSR 0x%x", addr)
+	}
+	return val, nil
+}
+
+func (s *simMSR) Write(cpu int, addr uint64, val uint64) error {
+	if err := s.checkCPU(cpu); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch addr {
+	case ADDRESSES.addrVoltageOffsets:
+		return s.writeMailbox(val)
+	case ADDRESSES.addrUnits, ADDRESSES.addrPlatformInfo, ADDRESSES.addrThermStatus, ADDRESSES.addrPkgThermStatus,
+		ADDRESSES.addrPkgEnergy, ADDRESSES.addrPP0Energy, ADDRESSES.addrPP1Energy, ADDRESSES.addrDRAMEnergy,
+		ADDRESSES.addrPerfLimitReasons, ADDRESSES.addrTSC, ADDRESSES.addrMPERF, ADDRESSES.addrAPERF:
+		return fmt.Errorf("simulated write to read-only MSR 0x%x", addr)
+	case ADDRESSES.addrPowerLimits:
+		if s.regs[addr]&(1<<63) != 0 {
+			return fmt.Errorf("simulated write to locked MSR 0x%x", addr)
+		}
+		s.regs[addr] = val
+	case ADDRESSES.addrTemp:
+		const tjMaxMask = 0xff << 16
+		s.regs[addr] = (s.regs[addr] & tjMaxMask) | (val &^ tjMaxMask)
+	default:
+		return fmt.Errorf("simulated write to unsupported MSR 0x%x", addr)
+	}
+	return nil
+}
+
+// writeMailbox decodes a command written to 0x150 (see packOffset).
+func (s *simMSR) writeMailbox(val uint64) error {
+	if val&(1<<63) == 0 {
+		return fmt.Errorf("simulated mailbox command 0x%x is missing the run bit", val)
+	}
+	planeIndex := int((val >> 40) & 0x7)
+	if planeIndex >= len(planes) {
+		return fmt.Errorf("simulated mailbox command for unsupported plane %d", planeIndex)
+	}
+	switch (val >> 32) & 0xff {
+	case 0x10: // read
+	case 0x11: // write
+		s.offsets[planeIndex] = uint32(val) & 0xffe00000
+	default:
+		return fmt.Errorf("simulated mailbox command 0x%x is not supported", val)
+	}
+	s.mailbox = uint64(planeIndex)<<40 | uint64(s.offsets[planeIndex])
+	return nil
+}
+
+// ---------- Dry-run Wrapper ----------
+
+// dryRunMSR prints every write with its decoded meaning instead of performing it.
+// Reads go to the wrapped device, except that registers "written" during the dry
+// run read back the pending value, so verification and --read behave as if the
+// writes had happened. Mailbox read commands are harmless and are forwarded.
+type dryRunMSR struct {
+	dev MSRDevice
+	out io.Writer
+
+	mu       sync.Mutex
+	pending  map[uint64]uint64 // addr -> value that would have been written
+	offsets  map[int]uint64    // plane index -> mailbox value that would have been written
+	selected int               // plane of the last forwarded mailbox read command, -1 if none
+}
+
+func newDryRunMSR(dev MSRDevice, out io.Writer) *dryRunMSR {
+	return &dryRunMSR{
+		dev:      dev,
+		out:      out,
+		pending:  make(map[uint64]uint64),
+		offsets:  make(map[int]uint64),
+		selected: -1,
+	}
+}
+
+func (d *dryRunMSR) CPUs() ([]int, error) {
+	return d.dev.CPUs()
+}
+
+func (d *dryRunMSR) Read(cpu int, addr uint64) (uint64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if addr == ADDRESSES.addrVoltageOffsets {
+		if val, ok := d.offsets[d.selected]; ok {
+			return uint64(d.selected)<<40 | val&0xffffffff, nil
+		}
+	} else if val, ok := d.pending[addr]; ok {
+		return val, nil
+	}
+	return d.dev.Read(cpu, addr)
+}
+
+func (d *dryRunMSR) Write(cpu int, addr uint64, val uint64) error {
+	if addr == ADDRESSES.addrVoltageOffsets && (val>>32)&1 == 0 {
+		// Read command: select the plane and let the hardware answer.
+		d.mu.Lock()
+		d.selected = int((val >> 40) & 0x7)
+		d.mu.Unlock()
+		return d.dev.Write(cpu, addr, val)
+	}
+
+	// writeMSR calls Write once per CPU; print each write only once.
+	cpus, err := d.dev.CPUs()
+	if err != nil {
+		return err
+	}
+	if len(cpus) > 0 && cpu != cpus[0] {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if addr == ADDRESSES.addrVoltageOffsets {
+		d.offsets[int((val>>40)&0x7)] = val
+	} else {
+		d.pending[addr] = val
+	}
+	desc := describeMSRWrite(d.dev, addr, val)
+	fmt.Fprintf(d.out, "[dry-run] MSR 0x%x <- 0x%016x (all %d CPUs): %s\n", addr, val, len(cpus), desc)
+	return nil
+}
+
+// describeMSRWrite decodes a value written to one of the registers undervolt-go uses.
+// dev is only read, for the units needed to decode power limits.
+func describeMSRWrite(dev MSRDevice, addr uint64, val uint64) string {
+	msr := ADDRESSES
+	switch addr {
+	case msr.addrVoltageOffsets:
+		planeIndex := int((val >> 40) & 0x7)
+		name := fmt.Sprintf("plane %d", planeIndex)
+		for _, p := range planes {
+			if p.Index == planeIndex {
+				name = p.Name
+			}
+		}
+		if (val>>32)&1 == 0 {
+			return "read " + name + " offset"
+		}
+		return fmt.Sprintf("set %s offset to %.2f mV", name, unconvertOffset(uint32(val)))
+	case msr.addrPowerLimits:
+		units, err := dev.Read(0, msr.addrUnits)
+		if err != nil {
+			return fmt.Sprintf("power limits (units unavailable: %v)", err)
+		}
+		pl := decodePowerLimit(val, units)
+		locked := ""
+		if pl.Locked {
+			locked = ", locked"
+		}
+		return fmt.Sprintf("P1 %.2fW/%.2fs %s, P2 %.2fW/%.2fs %s%s",
+			pl.LongTermPower, pl.LongTermTime, boolToEnabled(pl.LongTermEnabled),
+			pl.ShortTermPower, pl.ShortTermTime, boolToEnabled(pl.ShortTermEnabled), locked)
+	case msr.addrTemp:
+		tjMax := int((val >> 16) & 0xff)
+		offset := int((val & tccOffsetMask(msr)) >> 24)
+		return fmt.Sprintf("TCC offset %d (target %d°C, TjMax %d°C)", offset, tjMax-offset, tjMax)
+	}
+	return "unknown register"
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// withFlags runs set with the setting flags cleared and restores them afterwards.
+// The simulated backend keeps applyFlags from recording state files.
+func withFlags(t *testing.T, set func()) {
+	t.Helper()
+	saved := append([]float64(nil), planeOffsets...)
+	savedSim, savedForce := simulateFlag, forceFlag
+	t.Cleanup(func() {
+		copy(planeOffsets, saved)
+		simulateFlag, forceFlag = savedSim, savedForce
+		clearSettingFlags()
+	})
+	clearSettingFlags()
+	simulateFlag, forceFlag = true, false
+	set()
+}
+
+func setPlaneFlag(name string, mV float64) {
+	for i, p := range planes {
+		if p.Name == name {
+			planeOffsets[i] = mV
+		}
+	}
+}
+
+func TestApplyFlagsSimMSR(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     func()
+		lock    bool   // lock 0x610 before applying
+		wantErr string // substring of the expected error, "" for success
+		planes  map[string]float64
+		p1      *[2]float64 // expected long term power and time
+	}{
+		{
+			name:   "offsets round-trip",
+			set:    func() { setPlaneFlag("core", -80); setPlaneFlag("cache", -50.5) },
+			planes: map[string]float64{"core": -80, "cache": -50.5, "gpu": 0},
+		},
+		{
+			name:    "positive offset without force",
+			set:     func() { setPlaneFlag("core", 20) },
+			wantErr: "positive offset requires --force",
+			planes:  map[string]float64{"core": 0},
+		},
+		{
+			name:   "positive offset with force",
+			set:    func() { setPlaneFlag("core", 20); forceFlag = true },
+			planes: map[string]float64{"core": 20},
+		},
+		{
+			name: "power limit",
+			set:  func() { p1Args = []string{"35", "28"} },
+			p1:   &[2]float64{35, 28},
+		},
+		{
+			name:    "locked power limit",
+			set:     func() { p1Args = []string{"35", "28"} },
+			lock:    true,
+			wantErr: "locked",
+			p1:      &[2]float64{45, 28},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dev := newSimMSR(2)
+			if tt.lock {
+				dev.regs[ADDRESSES.addrPowerLimits] |= 1 << 63
+			}
+			withFlags(t, tt.set)
+			err := applyFlags(dev)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("applyFlags: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("applyFlags error = %v, want %q", err, tt.wantErr)
+			}
+			for plane, want := range tt.planes {
+				got, err := readOffset(dev, plane, ADDRESSES)
+				if err != nil {
+					t.Fatalf("readOffset(%s): %v", plane, err)
+				}
+				// The mailbox stores offsets in steps of 1/1.024 mV
+				if math.Abs(got-want) > 1 {
+					t.Errorf("%s offset = %.2f mV, want %.2f mV", plane, got, want)
+				}
+			}
+			if tt.p1 != nil {
+				pl, err := readPowerLimit(dev, ADDRESSES)
+				if err != nil {
+					t.Fatalf("readPowerLimit: %v", err)
+				}
+				if pl.LongTermPower != tt.p1[0] || math.Abs(pl.LongTermTime-tt.p1[1]) > 2 {
+					t.Errorf("P1 = %v W, %v s, want %v W, %v s", pl.LongTermPower, pl.LongTermTime, tt.p1[0], tt.p1[1])
+				}
+			}
+		})
+	}
+}
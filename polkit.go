@@ -0,0 +1,131 @@
+// polkit.go
+// Polkit actions, and the pkexec helpers that run control requests as root once polkit granted the action.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Polkit actions guarding the D-Bus methods and the pkexec helpers.
+const (
+	polkitActionPrefix  = "com.softorage.undervolt-go."
+	polkitActionRead    = polkitActionPrefix + "read"
+	polkitActionApply   = polkitActionPrefix + "apply"
+	polkitActionPersist = polkitActionPrefix + "persist"
+	polkitActionForce   = polkitActionPrefix + "force-positive"
+)
+
+// polkitHelperDir holds one helper per action, named after it without the prefix
+// (e.g. /usr/libexec/undervolt-go/apply). The policy maps each helper to its action
+// with org.freedesktop.policykit.exec.path, so pkexec asks for that action.
+const polkitHelperDir = "/usr/libexec/undervolt-go"
+
+// polkitActions are the actions of the pkexec helpers, in increasing order: the
+// helper of an action also runs the requests of the actions before it.
+var polkitActions = []string{polkitActionRead, polkitActionApply, polkitActionPersist, polkitActionForce}
+
+// polkitActionFor returns the action a control request needs.
+func polkitActionFor(req APIRequest) string {
+	switch {
+	case req.Force:
+		return polkitActionForce
+	case req.Op == apiOpApply && req.Persist,
+		req.Op == apiOpPersistDisable, req.Op == apiOpAutoSwitchEnable, req.Op == apiOpAutoSwitchDisable:
+		return polkitActionPersist
+	case req.Op == apiOpRead, req.Op == apiOpProfileList, req.Op == apiOpProfileGet:
+		return polkitActionRead
+	default:
+		return polkitActionApply
+	}
+}
+
+// pkexecCall runs req through the pkexec helper of the action it needs, letting
+// polkit authenticate the user. It answers like apiCall.
+func pkexecCall(req APIRequest) (APIResponse, error) {
+	var resp APIResponse
+	action := polkitActionFor(req)
+	helper := filepath.Join(polkitHelperDir, strings.TrimPrefix(action, polkitActionPrefix))
+	if _, err := os.Stat(helper); err != nil {
+		return resp, fmt.Errorf("the pkexec helper for %s is not installed: %w", action, err)
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("pkexec", helper)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			// pkexec exits with 126 when the dialog was dismissed and with 127 when not authorized
+			switch exitErr.ExitCode() {
+			case 126:
+				return resp, fmt.Errorf("authentication for %s was dismissed", action)
+			case 127:
+				return resp, fmt.Errorf("not authorized for %s", action)
+			}
+		}
+		return resp, fmt.Errorf("pkexec %s: %w: %s", helper, err, strings.TrimSpace(stderr.String()))
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return resp, fmt.Errorf("no response from the pkexec helper: %w", err)
+	}
+	if !resp.OK {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// ---------- Polkit Helper Command ----------
+
+var polkitHelperCmd = &cobra.Command{
+	Use:   "polkit-helper <action>",
+	Short: "Run one control request from standard input, once pkexec granted the polkit action",
+	Long: "Read one JSON control request (as on the 'serve' socket) from standard input, run it if the given " +
+		"polkit action covers it, and write the JSON response to standard output. The helpers in " +
+		polkitHelperDir + " run this through pkexec.",
+	Hidden: true,
+	Args:   cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		granted := polkitActionPrefix + args[0]
+		rank := slices.Index(polkitActions, granted)
+		if rank < 0 {
+			return fmt.Errorf("unknown polkit action %q", args[0])
+		}
+		var req APIRequest
+		if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+			return fmt.Errorf("invalid request: %w", err)
+		}
+
+		var resp APIResponse
+		if needed := polkitActionFor(req); slices.Index(polkitActions, needed) > rank {
+			resp.Error = fmt.Sprintf("%s needs the polkit action %s, not %s", req.Op, needed, granted)
+		} else {
+			// pkexec passes the uid of the user it authenticated
+			var origin *changeOrigin
+			if uid, err := strconv.ParseUint(os.Getenv("PKEXEC_UID"), 10, 32); err == nil {
+				origin = &changeOrigin{User: uidUser(uint32(uid)), Command: "polkit-helper: " + req.Op}
+			}
+			// Keep the messages printed while applying out of the response
+			out := os.Stdout
+			os.Stdout = os.Stderr
+			resp = (&apiServer{dev: openMSRDevice()}).handle(req, origin)
+			os.Stdout = out
+		}
+		return json.NewEncoder(os.Stdout).Encode(resp)
+	},
+}
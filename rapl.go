@@ -0,0 +1,251 @@
+// rapl.go
+// Power measurement from the RAPL energy counters, through the MSRs or the powercap interface.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Root of the powercap class directory, a parameter of the readers like hwmonRoot.
+const powercapRoot = "/sys/class/powercap"
+
+// energyCounter is one RAPL domain. The counter only grows and wraps around
+// after span counts; unit is the energy of one count in joules.
+type energyCounter struct {
+	domain string
+	read   func() (uint64, error)
+	span   uint64
+	unit   float64
+}
+
+// delta returns the counts between two readings, allowing for one wraparound.
+func (c energyCounter) delta(prev, cur uint64) uint64 {
+	if cur >= prev {
+		return cur - prev
+	}
+	return c.span - prev + cur
+}
+
+// msrEnergyCounters returns the energy status registers the CPU implements.
+// The package domain is required; the others are optional (PP1 exists on
+// client parts with an integrated GPU, DRAM mostly on servers).
+func msrEnergyCounters(dev MSRDevice, msr MSR) ([]energyCounter, error) {
+	units, err := readMSR(dev, msr.addrUnits, 0)
+	if err != nil {
+		return nil, err
+	}
+	unit := 1 / math.Pow(2, float64((units>>8)&0x1f))
+	var counters []energyCounter
+	for _, d := range []struct {
+		domain string
+		addr   uint64
+	}{
+		{"package", msr.addrPkgEnergy},
+		{"core", msr.addrPP0Energy},
+		{"uncore", msr.addrPP1Energy},
+		{"dram", msr.addrDRAMEnergy},
+	} {
+		read := func() (uint64, error) {
+			val, err := readMSR(dev, d.addr, 0)
+			return val & 0xffffffff, err
+		}
+		if _, err := read(); err != nil {
+			if d.domain == "package" {
+				return nil, err
+			}
+			continue
+		}
+		counters = append(counters, energyCounter{d.domain, read, 1 << 32, unit})
+	}
+	return counters, nil
+}
+
+// powercapEnergyCounters returns the intel-rapl zones below root that report their energy.
+// Since Linux 5.10 energy_uj is only readable by root.
+func powercapEnergyCounters(root string) ([]energyCounter, error) {
+	zones, err := filepath.Glob(filepath.Join(root, "intel-rapl:*"))
+	if err != nil {
+		return nil, err
+	}
+	var counters []energyCounter
+	var firstErr error
+	for _, zone := range zones {
+		path := filepath.Join(zone, "energy_uj")
+		read := func() (uint64, error) {
+			raw, err := readSysfsString(path)
+			if err != nil {
+				return 0, err
+			}
+			return strconv.ParseUint(raw, 10, 64)
+		}
+		if _, err := read(); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		span := uint64(math.MaxUint64)
+		if raw, err := readSysfsString(filepath.Join(zone, "max_energy_range_uj")); err == nil {
+			if max, err := strconv.ParseUint(raw, 10, 64); err == nil {
+				span = max + 1
+			}
+		}
+		name, err := readSysfsString(filepath.Join(zone, "name"))
+		if err != nil || name == "" {
+			name = filepath.Base(zone)
+		}
+		counters = append(counters, energyCounter{name, read, span, 1e-6})
+	}
+	if len(counters) == 0 {
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		return nil, fmt.Errorf("no intel-rapl zones in %s", root)
+	}
+	return counters, nil
+}
+
+// PowerReading is the average power of one RAPL domain over the sampling interval.
+type PowerReading struct {
+	Domain string  `json:"domain" yaml:"domain"`
+	Watts  float64 `json:"watts" yaml:"watts"`
+}
+
+// PowerReport is the output of 'sensors power'.
+type PowerReport struct {
+	Source    string         `json:"source,omitempty" yaml:"source,omitempty"` // "msr" or "powercap"
+	IntervalS float64        `json:"interval_s" yaml:"interval_s"`
+	Power     []PowerReading `json:"power" yaml:"power"`
+	Errors    []string       `json:"errors,omitempty" yaml:"errors,omitempty"`
+}
+
+// powerMeter turns successive energy counter readings into average power.
+type powerMeter struct {
+	source   string
+	counters []energyCounter
+	last     []uint64
+	ok       []bool // whether last holds a reading
+	lastAt   time.Time
+	now      func() time.Time
+}
+
+// newPowerMeter picks the MSR counters when dev is usable and the powercap zones
+// below root otherwise, and takes the first reading. The errors explain why a
+// source was not used; the meter is nil when neither is available.
+func newPowerMeter(root string, dev MSRDevice, devErr error) (*powerMeter, []string) {
+	var errs []string
+	m := &powerMeter{now: time.Now}
+	if dev != nil {
+		m.counters, devErr = msrEnergyCounters(dev, ADDRESSES)
+	}
+	if devErr == nil {
+		m.source = "msr"
+	} else {
+		errs = append(errs, "msr: "+devErr.Error())
+		counters, err := powercapEnergyCounters(root)
+		if err != nil {
+			return nil, append(errs, "powercap: "+err.Error())
+		}
+		m.source, m.counters = "powercap", counters
+	}
+	m.last = make([]uint64, len(m.counters))
+	m.ok = make([]bool, len(m.counters))
+	m.sample()
+	return m, errs
+}
+
+// sample returns the average power of every domain since the previous sample.
+// Domains that fail to read are reported as errors and restart on the next sample.
+func (m *powerMeter) sample() ([]PowerReading, []string) {
+	at := m.now()
+	elapsed := at.Sub(m.lastAt).Seconds()
+	var readings []PowerReading
+	var errs []string
+	for i, c := range m.counters {
+		val, err := c.read()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", c.domain, err))
+			m.ok[i] = false
+			continue
+		}
+		if m.ok[i] && elapsed > 0 {
+			joules := float64(c.delta(m.last[i], val)) * c.unit
+			readings = append(readings, PowerReading{c.domain, joules / elapsed})
+		}
+		m.last[i], m.ok[i] = val, true
+	}
+	m.lastAt = at
+	return readings, errs
+}
+
+// measurePower samples the RAPL counters twice, interval apart.
+// The 32-bit counters hold about 262 kJ at the usual 61 µJ unit and wrap after
+// tens of minutes at high package power (about 44 minutes at 100 W); delta
+// allows for one wraparound, so intervals must stay below that.
+func measurePower(root string, dev MSRDevice, devErr error, interval time.Duration) PowerReport {
+	r := PowerReport{IntervalS: interval.Seconds(), Power: []PowerReading{}}
+	m, errs := newPowerMeter(root, dev, devErr)
+	r.Errors = errs
+	if m == nil {
+		return r
+	}
+	r.Source = m.source
+	time.Sleep(interval)
+	readings, errs := m.sample()
+	r.Power = append(r.Power, readings...)
+	r.Errors = append(r.Errors, errs...)
+	return r
+}
+
+// writePowerText prints the power readings as a table.
+func writePowerText(w io.Writer, r PowerReport) {
+	if r.Source != "" {
+		fmt.Fprintf(w, "Average power over %gs (from %s):\n", r.IntervalS, r.Source)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, p := range r.Power {
+		fmt.Fprintf(tw, "%s\t%.2f W\n", p.Domain, p.Watts)
+	}
+	tw.Flush()
+	for _, e := range r.Errors {
+		fmt.Fprintf(w, "Not available: %s\n", e)
+	}
+}
+
+// ---------- Sensors Power Command ----------
+
+var sensorsPowerCmd = &cobra.Command{
+	Use:         "power",
+	Annotations: map[string]string{privilegesAnnotation: privilegesMSROptional},
+	Short:       "Measure the package, core, uncore and DRAM power from the RAPL energy counters",
+	Long: "Measure the average power of the RAPL domains (package, core, uncore, DRAM) over --interval.\n\n" +
+		"The energy counters are read from the MSRs, or from /sys/class/powercap when the MSRs are not " +
+		"accessible. Both need root privileges on current kernels.",
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateOutputFormat(outputFlag); err != nil {
+			return err
+		}
+		if err := checkSensorsInterval(); err != nil {
+			return err
+		}
+		setupLogging()
+		dev, devErr := sensorMSRDevice()
+		r := measurePower(powercapRoot, dev, devErr, sensorsIntervalFlag)
+		if outputFlag != outputText {
+			return encodeOutput(os.Stdout, r, outputFlag)
+		}
+		writePowerText(os.Stdout, r)
+		return nil
+	},
+}
@@ -0,0 +1,215 @@
+// report.go
+// Structured --read output (text, JSON and YAML).
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Supported values of --output.
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputYAML = "yaml"
+)
+
+// Report is the document printed by --read.
+type Report struct {
+	TemperatureTarget TemperatureReport `json:"temperature_target" yaml:"temperature_target"`
+	Planes            []PlaneReport     `json:"planes" yaml:"planes"`
+	TurboEnabled      *bool             `json:"turbo_enabled,omitempty" yaml:"turbo_enabled,omitempty"`
+	PowerLimit        *PowerLimitReport `json:"power_limit,omitempty" yaml:"power_limit,omitempty"`
+	PowerLimitError   string            `json:"power_limit_error,omitempty" yaml:"power_limit_error,omitempty"`
+	Persistence       PersistenceStatus `json:"persistence" yaml:"persistence"`
+}
+
+// PlaneReport is the read-back status of one voltage plane.
+// Exactly one of OffsetMV and Error is set.
+type PlaneReport struct {
+	Index    int      `json:"index" yaml:"index"`
+	Name     string   `json:"name" yaml:"name"`
+	OffsetMV *float64 `json:"offset_mv,omitempty" yaml:"offset_mv,omitempty"`
+	Error    string   `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// TemperatureReport describes the TCC temperature target.
+// Celsius is the target currently active in hardware; ACTarget and BatteryTarget
+// are the --temp/--temp-bat values of the last apply, when known.
+type TemperatureReport struct {
+	TjMax         int    `json:"tjmax" yaml:"tjmax"`
+	Offset        int    `json:"offset" yaml:"offset"`
+	Celsius       int    `json:"celsius" yaml:"celsius"`
+	ACTarget      *int   `json:"ac_target,omitempty" yaml:"ac_target,omitempty"`
+	BatteryTarget *int   `json:"battery_target,omitempty" yaml:"battery_target,omitempty"`
+	PowerSource   string `json:"power_source" yaml:"power_source"`
+}
+
+// PowerLimitTerm is one of the two RAPL package power limits.
+type PowerLimitTerm struct {
+	Enabled     bool    `json:"enabled" yaml:"enabled"`
+	PowerW      float64 `json:"power_w" yaml:"power_w"`
+	TimeWindowS float64 `json:"time_window_s" yaml:"time_window_s"`
+}
+
+// PowerLimitReport describes MSR 0x610.
+type PowerLimitReport struct {
+	P1     PowerLimitTerm `json:"p1" yaml:"p1"`
+	P2     PowerLimitTerm `json:"p2" yaml:"p2"`
+	Locked bool           `json:"locked" yaml:"locked"`
+}
+
+// validateOutputFormat rejects unknown --output values before anything is applied.
+func validateOutputFormat(format string) error {
+	switch format {
+	case outputText, outputJSON, outputYAML:
+		return nil
+	}
+	return fmt.Errorf("invalid output format %q (use text, json or yaml)", format)
+}
+
+// readReport collects the current settings from the hardware and the system.
+func readReport(dev MSRDevice, msr MSR) (Report, error) {
+	var r Report
+
+	temp, err := readTemperature(dev, msr)
+	if err != nil {
+		return r, err
+	}
+	r.TemperatureTarget = TemperatureReport{
+		TjMax:       temp.TjMax,
+		Offset:      temp.Offset,
+		Celsius:     temp.Target(),
+		PowerSource: currentPowerSource(),
+	}
+	if cfg, err := loadConfig(); err == nil {
+		r.TemperatureTarget.ACTarget = cfg.TempTargets.AC
+		r.TemperatureTarget.BatteryTarget = cfg.TempTargets.Battery
+	}
+
+	r.Planes = make([]PlaneReport, 0, len(planes))
+	for _, p := range planes {
+		pr := PlaneReport{Index: p.Index, Name: p.Name}
+		if voltage, err := readOffset(dev, p.Name, msr); err != nil {
+			pr.Error = err.Error()
+		} else {
+			pr.OffsetMV = &voltage
+		}
+		r.Planes = append(r.Planes, pr)
+	}
+
+	// Read turbo state.
+	if noTurbo, err := readTurbo(); err == nil {
+		enabled := noTurbo == 0
+		r.TurboEnabled = &enabled
+	}
+
+	plRead, err := readPowerLimit(dev, msr)
+	if err != nil {
+		r.PowerLimitError = err.Error()
+	} else {
+		r.PowerLimit = &PowerLimitReport{
+			P1:     PowerLimitTerm{plRead.LongTermEnabled, plRead.LongTermPower, plRead.LongTermTime},
+			P2:     PowerLimitTerm{plRead.ShortTermEnabled, plRead.ShortTermPower, plRead.ShortTermTime},
+			Locked: plRead.Locked,
+		}
+	}
+
+	r.Persistence = readPersistenceStatus()
+	return r, nil
+}
+
+// writeReport prints r to w in the requested format.
+func writeReport(w io.Writer, r Report, format string) error {
+	if format == outputText {
+		writeReportText(w, r)
+		return nil
+	}
+	return encodeOutput(w, r, format)
+}
+
+// encodeOutput prints v to w as JSON or YAML.
+func encodeOutput(w io.Writer, v any, format string) error {
+	switch format {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case outputYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	return validateOutputFormat(format)
+}
+
+// formatTemp prints an optional temperature, "-" when unset.
+func formatTemp(t *int) string {
+	if t == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d°C", *t)
+}
+
+// writeReportText prints r in the human readable --read layout.
+func writeReportText(w io.Writer, r Report) {
+	fmt.Fprintf(w, "Current Settings:\n\n")
+	tt := r.TemperatureTarget
+	fmt.Fprintf(w, "Temperature target: -%d (%d°C, TjMax %d°C)\n", tt.Offset, tt.Celsius, tt.TjMax)
+	if tt.ACTarget != nil || tt.BatteryTarget != nil {
+		fmt.Fprintf(w, "   AC: %s / Battery: %s / Active: %d°C (on %s)\n",
+			formatTemp(tt.ACTarget), formatTemp(tt.BatteryTarget), tt.Celsius, tt.PowerSource)
+	}
+	fmt.Fprintf(w, "Voltage Offsets:\n")
+	for _, p := range r.Planes {
+		if p.OffsetMV == nil {
+			fmt.Fprintf(w, "   %s: error: %s\n", p.Name, p.Error)
+			continue
+		}
+		fmt.Fprintf(w, "   %s: %.2f mV\n", p.Name, *p.OffsetMV)
+	}
+	if r.TurboEnabled != nil {
+		state := "enable"
+		if !*r.TurboEnabled {
+			state = "disable"
+		}
+		fmt.Fprintf(w, "Intel Turbo: %s\n", state)
+	}
+	if pl := r.PowerLimit; pl != nil {
+		locked := ""
+		if pl.Locked {
+			locked = " [locked]"
+		}
+		fmt.Fprintf(w, "Power limit:\n   %.2fW [P2 (short): %.2fs - %s]\n   %.2fW [P1 (long): %.2fs - %s]%s\n",
+			pl.P2.PowerW, pl.P2.TimeWindowS, boolToEnabled(pl.P2.Enabled),
+			pl.P1.PowerW, pl.P1.TimeWindowS, boolToEnabled(pl.P1.Enabled),
+			locked)
+	} else if r.PowerLimitError != "" {
+		fmt.Fprintf(w, "Power limit: error: %s\n", r.PowerLimitError)
+	}
+
+	fmt.Fprintf(w, "\nBoot/Resume Persistence Status:\n")
+	if !r.Persistence.Enabled {
+		fmt.Fprintln(w, "   Status: DISABLED")
+		return
+	}
+	fmt.Fprintln(w, "   Status: ENABLED (systemd service active)")
+	if r.Persistence.ExecStart != "" {
+		fmt.Fprintf(w, "   Active Command: %s\n", r.Persistence.ExecStart)
+	} else {
+		// Fallback in case the file exists but is malformed
+		fmt.Fprintln(w, "   Active Command: [Service active, but ExecStart could not be parsed]")
+	}
+	if r.Persistence.BootGuard == bootGuardTripped {
+		fmt.Fprintln(w, "   Boot Guard: TRIPPED (settings skipped on boot; see 'undervolt-go boot-guard status')")
+	} else if r.Persistence.BootGuard != "" {
+		fmt.Fprintf(w, "   Boot Guard: %s\n", r.Persistence.BootGuard)
+	}
+}
@@ -0,0 +1,384 @@
+// sensors.go
+// Native sensor readings (temperatures, fans) from hwmon, procfs and the thermal MSRs, and the sensors command.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Root of the hwmon class directory; the readers take it as a parameter so that
+// they can also walk a copy of the tree.
+const hwmonRoot = "/sys/class/hwmon"
+
+// readSysfsString reads a sysfs attribute without the trailing newline.
+func readSysfsString(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// numericSuffix returns the number at the end of s, or -1.
+func numericSuffix(s string) int {
+	i := len(s)
+	for i > 0 && s[i-1] >= '0' && s[i-1] <= '9' {
+		i--
+	}
+	n, err := strconv.Atoi(s[i:])
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// hwmonDevices returns the hwmon device directories below root in numeric order.
+func hwmonDevices(root string) ([]string, error) {
+	dirs, err := filepath.Glob(filepath.Join(root, "hwmon*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(dirs, func(i, j int) bool { return numericSuffix(dirs[i]) < numericSuffix(dirs[j]) })
+	return dirs, nil
+}
+
+// hwmonChannels returns the channel numbers of kind ("temp", "fan") that have an input file in dir.
+func hwmonChannels(dir, kind string) []int {
+	inputs, _ := filepath.Glob(filepath.Join(dir, kind+"*_input"))
+	var channels []int
+	for _, input := range inputs {
+		if n := numericSuffix(strings.TrimSuffix(filepath.Base(input), "_input")); n >= 0 {
+			channels = append(channels, n)
+		}
+	}
+	sort.Ints(channels)
+	return channels
+}
+
+// hwmonChipName returns the driver name of a hwmon device (e.g. coretemp, acpitz).
+func hwmonChipName(dir string) string {
+	if name, err := readSysfsString(filepath.Join(dir, "name")); err == nil && name != "" {
+		return name
+	}
+	return filepath.Base(dir)
+}
+
+// hwmonLabel returns the label of a channel, or its attribute name when it has none.
+func hwmonLabel(dir, kind string, channel int) string {
+	if label, err := readSysfsString(filepath.Join(dir, fmt.Sprintf("%s%d_label", kind, channel))); err == nil && label != "" {
+		return label
+	}
+	return fmt.Sprintf("%s%d", kind, channel)
+}
+
+// ---------- Temperatures ----------
+
+// TempReading is one temperature sensor.
+type TempReading struct {
+	Chip string `json:"chip" yaml:"chip"` // hwmon driver name, or "msr" for the thermal MSRs
+	// hwmon device (e.g. hwmon3), which tells apart chips of the same driver
+	// (two NVMe drives, the coretemp of each socket)
+	Device  string  `json:"device,omitempty" yaml:"device,omitempty"`
+	Label   string  `json:"label" yaml:"label"`
+	Celsius float64 `json:"celsius" yaml:"celsius"`
+}
+
+// TempsReport is the output of 'sensors temps'.
+type TempsReport struct {
+	Temps  []TempReading `json:"temps" yaml:"temps"`
+	Errors []string      `json:"errors,omitempty" yaml:"errors,omitempty"`
+}
+
+// readHwmonTemps reads every temp*_input of the hwmon devices below root.
+// Channels that fail to read (common for absent sensors) are skipped.
+func readHwmonTemps(root string) ([]TempReading, error) {
+	dirs, err := hwmonDevices(root)
+	if err != nil {
+		return nil, err
+	}
+	var temps []TempReading
+	for _, dir := range dirs {
+		chip := hwmonChipName(dir)
+		for _, ch := range hwmonChannels(dir, "temp") {
+			raw, err := readSysfsString(filepath.Join(dir, fmt.Sprintf("temp%d_input", ch)))
+			if err != nil {
+				continue
+			}
+			milli, err := strconv.Atoi(raw)
+			if err != nil {
+				continue
+			}
+			temps = append(temps, TempReading{Chip: chip, Device: filepath.Base(dir), Label: hwmonLabel(dir, "temp", ch), Celsius: float64(milli) / 1000})
+		}
+	}
+	return temps, nil
+}
+
+// readMSRTemps reads the digital thermal readout of every CPU (0x19c) and of the
+// package (0x1b1). The readouts count degrees below TjMax.
+func readMSRTemps(dev MSRDevice, msr MSR) ([]TempReading, error) {
+	target, err := readTemperature(dev, msr)
+	if err != nil {
+		return nil, err
+	}
+	if target.TjMax == 0 {
+		return nil, fmt.Errorf("CPU does not report TjMax")
+	}
+	cpus, err := dev.CPUs()
+	if err != nil {
+		return nil, err
+	}
+	var temps []TempReading
+	for _, cpu := range cpus {
+		val, err := readMSR(dev, msr.addrThermStatus, cpu)
+		if err != nil {
+			return temps, err
+		}
+		if val&(1<<31) == 0 {
+			continue // reading not valid
+		}
+		readout := int((val >> 16) & 0x7f)
+		temps = append(temps, TempReading{Chip: "msr", Label: fmt.Sprintf("CPU %d", cpu), Celsius: float64(target.TjMax - readout)})
+	}
+	val, err := readMSR(dev, msr.addrPkgThermStatus, 0)
+	if err != nil {
+		return temps, err
+	}
+	if val&(1<<31) == 0 {
+		return temps, nil // reading not valid
+	}
+	readout := int((val >> 16) & 0x7f)
+	return append(temps, TempReading{Chip: "msr", Label: "Package", Celsius: float64(target.TjMax - readout)}), nil
+}
+
+// sensorMSRDevice returns the MSR device for sensor readings. Unlike the
+// settings commands, the sensors work without root, just without the MSRs.
+func sensorMSRDevice() (MSRDevice, error) {
+	if !simulateFlag && os.Geteuid() != 0 {
+		return nil, fmt.Errorf("reading the MSRs needs root privileges")
+	}
+	return openMSRDevice(), nil
+}
+
+// readTemps collects the hwmon temperatures below root and, when dev is not nil, the MSR readouts.
+func readTemps(root string, dev MSRDevice, devErr error) TempsReport {
+	var r TempsReport
+	temps, err := readHwmonTemps(root)
+	if err != nil {
+		r.Errors = append(r.Errors, "hwmon: "+err.Error())
+	}
+	r.Temps = append(r.Temps, temps...)
+	if dev == nil {
+		r.Errors = append(r.Errors, "msr: "+devErr.Error())
+		return r
+	}
+	msrTemps, err := readMSRTemps(dev, ADDRESSES)
+	if err != nil {
+		r.Errors = append(r.Errors, "msr: "+err.Error())
+	}
+	r.Temps = append(r.Temps, msrTemps...)
+	return r
+}
+
+// writeTempsText prints the temperatures as a table.
+func writeTempsText(w io.Writer, r TempsReport) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, t := range r.Temps {
+		device := t.Device
+		if device == "" {
+			device = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%.1f°C\n", t.Chip, device, t.Label, t.Celsius)
+	}
+	tw.Flush()
+	for _, e := range r.Errors {
+		fmt.Fprintf(w, "Not available: %s\n", e)
+	}
+}
+
+// ---------- Fans ----------
+
+// ThinkPad fan status file of the thinkpad_acpi driver.
+const thinkpadFanPath = "/proc/acpi/ibm/fan"
+
+// FanReading is one fan.
+type FanReading struct {
+	Chip  string `json:"chip" yaml:"chip"` // hwmon driver name, or "thinkpad_acpi" for /proc/acpi/ibm/fan
+	Label string `json:"label" yaml:"label"`
+	RPM   int    `json:"rpm" yaml:"rpm"`
+	Level string `json:"level,omitempty" yaml:"level,omitempty"` // ThinkPad fan level (auto, 0-7, full-speed, ...)
+}
+
+// FansReport is the output of 'sensors fans'.
+type FansReport struct {
+	Fans   []FanReading `json:"fans" yaml:"fans"`
+	Errors []string     `json:"errors,omitempty" yaml:"errors,omitempty"`
+}
+
+// readHwmonFans reads every fan*_input of the hwmon devices below root.
+func readHwmonFans(root string) ([]FanReading, error) {
+	dirs, err := hwmonDevices(root)
+	if err != nil {
+		return nil, err
+	}
+	var fans []FanReading
+	for _, dir := range dirs {
+		chip := hwmonChipName(dir)
+		for _, ch := range hwmonChannels(dir, "fan") {
+			raw, err := readSysfsString(filepath.Join(dir, fmt.Sprintf("fan%d_input", ch)))
+			if err != nil {
+				continue
+			}
+			rpm, err := strconv.Atoi(raw)
+			if err != nil {
+				continue
+			}
+			fans = append(fans, FanReading{Chip: chip, Label: hwmonLabel(dir, "fan", ch), RPM: rpm})
+		}
+	}
+	return fans, nil
+}
+
+// readThinkpadFan parses the ThinkPad fan status file:
+//
+//	status:		enabled
+//	speed:		2700
+//	level:		auto
+func readThinkpadFan(path string) (FanReading, error) {
+	fan := FanReading{Chip: "thinkpad_acpi", Label: "fan"}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fan, err
+	}
+	haveSpeed := false
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "speed":
+			if fan.RPM, err = strconv.Atoi(value); err != nil {
+				return fan, fmt.Errorf("%s: invalid speed %q", path, value)
+			}
+			haveSpeed = true
+		case "level":
+			fan.Level = value
+		}
+	}
+	if !haveSpeed {
+		return fan, fmt.Errorf("%s: no fan speed", path)
+	}
+	return fan, nil
+}
+
+// readFans collects the hwmon fans below root and the ThinkPad fan at thinkpadPath, if present.
+func readFans(root, thinkpadPath string) FansReport {
+	var r FansReport
+	fans, err := readHwmonFans(root)
+	if err != nil {
+		r.Errors = append(r.Errors, "hwmon: "+err.Error())
+	}
+	r.Fans = fans
+	// thinkpad_acpi also registers its fan with hwmon (as "thinkpad"); only the
+	// procfs file reports the fan level, so prefer it over the hwmon duplicate.
+	fan, err := readThinkpadFan(thinkpadPath)
+	switch {
+	case err == nil:
+		r.Fans = slices.DeleteFunc(r.Fans, func(f FanReading) bool { return f.Chip == "thinkpad" })
+		r.Fans = append(r.Fans, fan)
+	case !os.IsNotExist(err):
+		r.Errors = append(r.Errors, "thinkpad_acpi: "+err.Error())
+	}
+	return r
+}
+
+// writeFansText prints the fans as a table.
+func writeFansText(w io.Writer, r FansReport) {
+	if len(r.Fans) == 0 && len(r.Errors) == 0 {
+		fmt.Fprintln(w, "No fan sensors found.")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, f := range r.Fans {
+		level := ""
+		if f.Level != "" {
+			level = "\t(level " + f.Level + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d RPM%s\n", f.Chip, f.Label, f.RPM, level)
+	}
+	tw.Flush()
+	for _, e := range r.Errors {
+		fmt.Fprintf(w, "Not available: %s\n", e)
+	}
+}
+
+// ---------- Sensors Command ----------
+
+var sensorsCmd = &cobra.Command{
+	Use:   "sensors",
+	Short: "Read temperatures, fan speeds, power and frequencies without lm-sensors",
+}
+
+// sensorsIntervalFlag is the --interval of the sensors that measure a rate (power, freq).
+var sensorsIntervalFlag time.Duration
+
+// checkSensorsInterval keeps --interval below a minute, well within the time
+// the RAPL energy counters take to wrap around once.
+func checkSensorsInterval() error {
+	if sensorsIntervalFlag <= 0 || sensorsIntervalFlag > time.Minute {
+		return fmt.Errorf("--interval must be between 0 and 1m, got %v", sensorsIntervalFlag)
+	}
+	return nil
+}
+
+var sensorsTempsCmd = &cobra.Command{
+	Use:         "temps",
+	Annotations: map[string]string{privilegesAnnotation: privilegesMSROptional},
+	Short:       "Show temperatures from hwmon and, as root, the CPU thermal MSRs",
+	Args:        cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateOutputFormat(outputFlag); err != nil {
+			return err
+		}
+		setupLogging()
+		dev, devErr := sensorMSRDevice()
+		r := readTemps(hwmonRoot, dev, devErr)
+		if outputFlag != outputText {
+			return encodeOutput(os.Stdout, r, outputFlag)
+		}
+		writeTempsText(os.Stdout, r)
+		return nil
+	},
+}
+
+var sensorsFansCmd = &cobra.Command{
+	Use:         "fans",
+	Annotations: map[string]string{privilegesAnnotation: privilegesNone},
+	Short:       "Show fan speeds from hwmon and the ThinkPad fan interface",
+	Args:        cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateOutputFormat(outputFlag); err != nil {
+			return err
+		}
+		r := readFans(hwmonRoot, thinkpadFanPath)
+		if outputFlag != outputText {
+			return encodeOutput(os.Stdout, r, outputFlag)
+		}
+		writeFansText(os.Stdout, r)
+		return nil
+	},
+}
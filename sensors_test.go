@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testdata/hwmon holds acpitz (no labels), coretemp (with a garbage, an
+// unreadable and an input-less channel), x86_pkg_temp, the thinkpad fans, two
+// NVMe drives and hwmon10, which sorts after hwmon5.
+const testHwmonRoot = "testdata/hwmon"
+
+func TestReadHwmonTemps(t *testing.T) {
+	temps, err := readHwmonTemps(testHwmonRoot)
+	if err != nil {
+		t.Fatalf("readHwmonTemps: %v", err)
+	}
+	want := []TempReading{
+		{"acpitz", "hwmon0", "temp1", 27.8},
+		{"coretemp", "hwmon1", "Package id 0", 45},
+		{"coretemp", "hwmon1", "Core 0", 43},
+		{"x86_pkg_temp", "hwmon2", "temp1", 46},
+		{"nvme", "hwmon4", "Composite", 35.85},
+		{"nvme", "hwmon5", "Composite", 41.85},
+		{"nct6775", "hwmon10", "temp1", 38.5},
+	}
+	if !reflect.DeepEqual(temps, want) {
+		t.Errorf("readHwmonTemps =\n%v\nwant\n%v", temps, want)
+	}
+}
+
+func TestReadHwmonFans(t *testing.T) {
+	fans, err := readHwmonFans(testHwmonRoot)
+	if err != nil {
+		t.Fatalf("readHwmonFans: %v", err)
+	}
+	want := []FanReading{
+		{Chip: "thinkpad", Label: "fan1", RPM: 2650},
+		{Chip: "nct6775", Label: "CPU Fan", RPM: 1200},
+	}
+	if !reflect.DeepEqual(fans, want) {
+		t.Errorf("readHwmonFans = %v, want %v", fans, want)
+	}
+}
+
+func TestReadThinkpadFan(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	tests := []struct {
+		name    string
+		path    string
+		want    FanReading
+		wantErr bool
+	}{
+		{"fixture", "testdata/thinkpad_fan", FanReading{Chip: "thinkpad_acpi", Label: "fan", RPM: 2700, Level: "auto"}, false},
+		{"garbage speed", write("garbage", "status:\t\tenabled\nspeed:\t\tfast\n"), FanReading{}, true},
+		{"no speed", write("nospeed", "status:\t\tdisabled\n"), FanReading{}, true},
+		{"missing", filepath.Join(dir, "missing"), FanReading{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fan, err := readThinkpadFan(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readThinkpadFan error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && fan != tt.want {
+				t.Errorf("readThinkpadFan = %+v, want %+v", fan, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadFansPrefersThinkpadACPI(t *testing.T) {
+	r := readFans(testHwmonRoot, "testdata/thinkpad_fan")
+	if len(r.Errors) != 0 {
+		t.Errorf("errors: %v", r.Errors)
+	}
+	want := []FanReading{
+		{Chip: "nct6775", Label: "CPU Fan", RPM: 1200},
+		{Chip: "thinkpad_acpi", Label: "fan", RPM: 2700, Level: "auto"},
+	}
+	if !reflect.DeepEqual(r.Fans, want) {
+		t.Errorf("readFans = %v, want %v", r.Fans, want)
+	}
+}
+
+func TestReadMSRTemps(t *testing.T) {
+	dev := newSimMSR(2)
+	temps, err := readMSRTemps(dev, ADDRESSES)
+	if err != nil {
+		t.Fatalf("readMSRTemps: %v", err)
+	}
+	want := []TempReading{
+		{Chip: "msr", Label: "CPU 0", Celsius: 43},
+		{Chip: "msr", Label: "CPU 1", Celsius: 43},
+		{Chip: "msr", Label: "Package", Celsius: 45},
+	}
+	if !reflect.DeepEqual(temps, want) {
+		t.Errorf("readMSRTemps = %v, want %v", temps, want)
+	}
+
+	// Stale readings, with the reading-valid bit clear, are skipped
+	dev.regs[ADDRESSES.addrThermStatus] &^= 1 << 31
+	dev.regs[ADDRESSES.addrPkgThermStatus] &^= 1 << 31
+	if temps, err := readMSRTemps(dev, ADDRESSES); err != nil || len(temps) != 0 {
+		t.Errorf("readMSRTemps without valid readings = %v, %v; want none", temps, err)
+	}
+}
+
+func TestWriteTempsText(t *testing.T) {
+	var out strings.Builder
+	writeTempsText(&out, TempsReport{Temps: []TempReading{
+		{"nvme", "hwmon4", "Composite", 35.85},
+		{"nvme", "hwmon5", "Composite", 41.85},
+		{Chip: "msr", Label: "Package", Celsius: 45},
+	}})
+	want := "nvme  hwmon4  Composite  35.9°C\n" +
+		"nvme  hwmon5  Composite  41.9°C\n" +
+		"msr   -       Package    45.0°C\n"
+	if out.String() != want {
+		t.Errorf("writeTempsText =\n%s\nwant\n%s", out.String(), want)
+	}
+}
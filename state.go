@@ -0,0 +1,270 @@
+// state.go
+// Raw capture and restore of the hardware state, the pre-change snapshot and the reset command.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// regValue is a raw 64-bit register value, stored as hex in state files.
+type regValue uint64
+
+func (v regValue) MarshalYAML() (any, error) {
+	return fmt.Sprintf("0x%016x", uint64(v)), nil
+}
+
+func (v *regValue) UnmarshalYAML(n *yaml.Node) error {
+	u, err := strconv.ParseUint(n.Value, 0, 64)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid register value %q", n.Line, n.Value)
+	}
+	*v = regValue(u)
+	return nil
+}
+
+// HardwareState is a capture of every value undervolt-go can modify.
+// Values that could not be read are left unset and are not restored.
+type HardwareState struct {
+	Time        time.Time          `yaml:"time"`
+	Planes      map[string]float64 `yaml:"planes,omitempty"`       // offset in mV by plane name
+	PowerLimits *regValue          `yaml:"power_limits,omitempty"` // raw MSR 0x610
+	TempTarget  *regValue          `yaml:"temp_target,omitempty"`  // raw MSR 0x1a2
+	NoTurbo     *int               `yaml:"no_turbo,omitempty"`
+}
+
+// recordsState reports whether state files (snapshot, history, recorded targets) are read and written.
+// The simulated device's registers are not the machine's, and a dry run changes nothing,
+// so nothing is recorded for either.
+func recordsState() bool {
+	return !simulateFlag && !dryRunFlag
+}
+
+// captureState reads the current hardware state.
+func captureState(dev MSRDevice, msr MSR) HardwareState {
+	st := HardwareState{Time: time.Now(), Planes: make(map[string]float64, len(planes))}
+	for _, p := range planes {
+		if mV, err := readOffset(dev, p.Name, msr); err == nil {
+			st.Planes[p.Name] = mV
+		}
+	}
+	if val, err := readMSR(dev, msr.addrPowerLimits, 0); err == nil {
+		rv := regValue(val)
+		st.PowerLimits = &rv
+	}
+	if val, err := readMSR(dev, msr.addrTemp, 0); err == nil {
+		rv := regValue(val)
+		st.TempTarget = &rv
+	}
+	if noTurbo, err := readTurbo(); err == nil {
+		st.NoTurbo = &noTurbo
+	}
+	return st
+}
+
+// restoreState writes every value present in st back to the hardware.
+// It keeps going after a failure so that as much as possible is restored.
+func restoreState(dev MSRDevice, msr MSR, st HardwareState) error {
+	var errs []error
+	for _, p := range planes {
+		mV, ok := st.Planes[p.Name]
+		if !ok {
+			continue
+		}
+		// Restoring is allowed to write positive offsets, they were there before.
+		if err := setOffset(dev, p.Name, mV, msr, true); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if st.TempTarget != nil {
+		if err := restoreTempTarget(dev, msr, uint64(*st.TempTarget)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if st.PowerLimits != nil {
+		if err := restorePowerLimits(dev, msr, uint64(*st.PowerLimits)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if st.NoTurbo != nil {
+		if err := setTurbo(*st.NoTurbo); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// restoreTempTarget restores the TCC offset field of a raw 0x1a2 value.
+func restoreTempTarget(dev MSRDevice, msr MSR, raw uint64) error {
+	cur, err := readMSR(dev, msr.addrTemp, 0)
+	if err != nil {
+		return err
+	}
+	mask := tccOffsetMask(msr)
+	val := (cur &^ mask) | (raw & mask)
+	if val == cur {
+		return nil
+	}
+	return writeMSR(dev, val, msr.addrTemp)
+}
+
+// restorePowerLimits writes a raw 0x610 value back and verifies it.
+func restorePowerLimits(dev MSRDevice, msr MSR, raw uint64) error {
+	cur, err := readMSR(dev, msr.addrPowerLimits, 0)
+	if err != nil {
+		return err
+	}
+	if cur == raw {
+		return nil
+	}
+	if cur&(1<<63) != 0 {
+		return fmt.Errorf("cannot restore power limit because it is locked (reboot to unlock)")
+	}
+	if err := writeMSR(dev, raw, msr.addrPowerLimits); err != nil {
+		return err
+	}
+	newVal, err := readMSR(dev, msr.addrPowerLimits, 0)
+	if err != nil {
+		return err
+	}
+	if newVal != raw {
+		return fmt.Errorf("failed to restore power limit: tried to set 0x%x, read 0x%x", raw, newVal)
+	}
+	return nil
+}
+
+// writeStateFile stores st as YAML at path.
+func writeStateFile(path string, st HardwareState) error {
+	data, err := yaml.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// readStateFile loads a HardwareState written by writeStateFile.
+func readStateFile(path string) (HardwareState, error) {
+	var st HardwareState
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return st, err
+	}
+	if err := yaml.Unmarshal(data, &st); err != nil {
+		return st, fmt.Errorf("%s: %w", path, err)
+	}
+	return st, nil
+}
+
+// ---------- Pre-change Snapshot ----------
+
+// The snapshot holds the stock values, taken before undervolt-go first modifies anything.
+const snapshotFileName = "snapshot.yaml"
+
+func snapshotPath() string {
+	return filepath.Join(configDir(), snapshotFileName)
+}
+
+// ensureSnapshot saves st, the state before a modification, unless a snapshot already exists.
+func ensureSnapshot(st HardwareState) error {
+	if !recordsState() {
+		return nil
+	}
+	if _, err := os.Stat(snapshotPath()); err == nil {
+		return nil
+	}
+	if err := writeStateFile(snapshotPath(), st); err != nil {
+		return err
+	}
+	fmt.Printf("Saved stock values to %s (restore them with 'reset').\n", snapshotPath())
+	return nil
+}
+
+// flagsModifyHardware reports whether applyFlags is going to write anything.
+func flagsModifyHardware() bool {
+	for _, mV := range planeOffsets {
+		if !math.IsNaN(mV) {
+			return true
+		}
+	}
+	return tempFlag > 0 || tempBatFlag > 0 || turboFlag >= 0 ||
+		len(p1Args) > 0 || len(p2Args) > 0 || lockPowerLimit
+}
+
+// ---------- Reset Command ----------
+
+var resetDisableAutoSwitch bool
+
+var resetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Restore stock voltage offsets, temperature target, turbo and power limits",
+	Long: "Zero all voltage offsets and restore the temperature target, turbo state and power limits " +
+		"recorded before undervolt-go first changed them.\n\n" +
+		"Use --disable-persist and --disable-auto-switch to also remove the boot/resume service and automatic profile switching.",
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		setupLogging()
+		dev := openMSRDevice()
+		msr := ADDRESSES
+
+		// The snapshot is read even on a dry run, which shows the writes of the real reset.
+		var st HardwareState
+		haveSnapshot := false
+		snap, err := readStateFile(snapshotPath())
+		switch {
+		case err == nil:
+			st = snap
+			haveSnapshot = true
+		case os.IsNotExist(err):
+			fmt.Println("No snapshot of stock values found; only voltage offsets will be reset.")
+		default:
+			fmt.Fprintf(os.Stderr, "Warning: ignoring unreadable snapshot: %v\n", err)
+		}
+
+		// Remove the units first: when the restore fails (e.g. a locked 0x610),
+		// they must not reapply the settings being rolled back on the next boot.
+		var unitErr error
+		if disablePersistFlag {
+			if err := disablePersistence(); err != nil {
+				unitErr = fmt.Errorf("error disabling persistence: %w", err)
+			}
+		}
+		if resetDisableAutoSwitch {
+			disableAutoSwitch()
+		}
+
+		_, record := trackChange(dev, msr)
+		defer record()
+
+		// Stock offsets are always zero.
+		st.Planes = make(map[string]float64, len(planes))
+		for _, p := range planes {
+			st.Planes[p.Name] = 0
+		}
+		if err := restoreState(dev, msr, st); err != nil {
+			return errors.Join(fmt.Errorf("reset incomplete: %w", err), unitErr)
+		}
+		fmt.Println("Voltage offsets reset to 0 mV.")
+		if haveSnapshot {
+			fmt.Printf("Temperature target, turbo and power limits restored from %s.\n", snapshotPath())
+		}
+		// The machine is stock again; the next change takes a fresh snapshot.
+		if haveSnapshot && recordsState() {
+			if err := os.Remove(snapshotPath()); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: could not remove snapshot: %v\n", err)
+			}
+		}
+		return unitErr
+	},
+}
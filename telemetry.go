@@ -0,0 +1,409 @@
+// telemetry.go
+// Effective frequency from APERF/MPERF, the throttle status of the thermal and perf-limit MSRs,
+// and the telemetry session that watch and log read everything through.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// ---------- Effective Frequency ----------
+
+// cpuCounters is one reading of the frequency counters of a CPU.
+type cpuCounters struct {
+	aperf, mperf, tsc uint64
+}
+
+// CPUFrequency is the activity of one CPU over the sampling interval, as turbostat reports it.
+type CPUFrequency struct {
+	CPU     int     `json:"cpu" yaml:"cpu"`
+	AvgMHz  float64 `json:"avg_mhz" yaml:"avg_mhz"`   // cycles per second of wall time
+	BusyPct float64 `json:"busy_pct" yaml:"busy_pct"` // share of the time spent in C0
+	BusyMHz float64 `json:"busy_mhz" yaml:"busy_mhz"` // frequency while in C0
+}
+
+// freqMeter turns successive APERF/MPERF/TSC readings into effective frequencies.
+type freqMeter struct {
+	dev    MSRDevice
+	msr    MSR
+	cpus   []int
+	last   map[int]cpuCounters
+	lastAt time.Time
+	now    func() time.Time
+}
+
+// newFreqMeter takes the first reading of every CPU.
+func newFreqMeter(dev MSRDevice, msr MSR) (*freqMeter, error) {
+	cpus, err := dev.CPUs()
+	if err != nil {
+		return nil, err
+	}
+	m := &freqMeter{dev: dev, msr: msr, cpus: cpus, last: map[int]cpuCounters{}, now: time.Now}
+	if _, errs := m.sample(); len(m.last) == 0 {
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("%s", errs[0])
+		}
+		return nil, fmt.Errorf("no CPUs to read")
+	}
+	return m, nil
+}
+
+func (m *freqMeter) read(cpu int) (cpuCounters, error) {
+	var c cpuCounters
+	var err error
+	if c.aperf, err = readMSR(m.dev, m.msr.addrAPERF, cpu); err != nil {
+		return c, err
+	}
+	if c.mperf, err = readMSR(m.dev, m.msr.addrMPERF, cpu); err != nil {
+		return c, err
+	}
+	c.tsc, err = readMSR(m.dev, m.msr.addrTSC, cpu)
+	return c, err
+}
+
+// sample returns the activity of every CPU since the previous sample.
+// The counters are 64 bits wide and do not wrap in practice.
+func (m *freqMeter) sample() ([]CPUFrequency, []string) {
+	at := m.now()
+	elapsed := at.Sub(m.lastAt).Seconds()
+	var freqs []CPUFrequency
+	var errs []string
+	for _, cpu := range m.cpus {
+		cur, err := m.read(cpu)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("CPU %d: %v", cpu, err))
+			delete(m.last, cpu)
+			continue
+		}
+		prev, ok := m.last[cpu]
+		m.last[cpu] = cur
+		if !ok || elapsed <= 0 {
+			continue
+		}
+		aperf, mperf, tsc := float64(cur.aperf-prev.aperf), float64(cur.mperf-prev.mperf), float64(cur.tsc-prev.tsc)
+		f := CPUFrequency{CPU: cpu, AvgMHz: aperf / elapsed / 1e6}
+		if tsc > 0 {
+			f.BusyPct = 100 * mperf / tsc
+		}
+		if mperf > 0 {
+			f.BusyMHz = tsc / elapsed / 1e6 * aperf / mperf
+		}
+		freqs = append(freqs, f)
+	}
+	m.lastAt = at
+	return freqs, errs
+}
+
+// ---------- Throttling ----------
+
+// Limiters that can throttle the CPU, in the order they are reported.
+const (
+	limiterPL1        = "PL1"
+	limiterPL2        = "PL2"
+	limiterPowerLimit = "power limit" // PL1 or PL2, when the CPU does not say which
+	limiterThermal    = "thermal"
+	limiterPROCHOT    = "PROCHOT"
+	limiterCurrent    = "current"
+)
+
+var limiterOrder = []string{limiterPL1, limiterPL2, limiterPowerLimit, limiterThermal, limiterPROCHOT, limiterCurrent}
+
+// throttleBit is a status bit of a throttle register; its sticky log bit is at logShift above it.
+type throttleBit struct {
+	bit     uint
+	limiter string
+}
+
+// Status bits of IA32_THERM_STATUS (per core) and IA32_PACKAGE_THERM_STATUS;
+// each log bit follows its status bit. The package register has no current limit.
+var thermStatusBits = []throttleBit{
+	{0, limiterThermal},
+	{2, limiterPROCHOT},
+	{10, limiterPowerLimit},
+	{12, limiterCurrent},
+}
+
+// Status bits of MSR_CORE_PERF_LIMIT_REASONS (0x64f); the log bits are 16 above.
+var perfLimitBits = []throttleBit{
+	{0, limiterPROCHOT},
+	{1, limiterThermal},
+	{7, limiterCurrent}, // VR thermal design current
+	{10, limiterPL1},
+	{11, limiterPL2},
+}
+
+// ThrottleStatus lists the limiters that throttle the CPU now, and those that
+// throttled it since the log bits were last cleared (usually since boot).
+type ThrottleStatus struct {
+	Active []string `json:"active" yaml:"active"`
+	Logged []string `json:"logged" yaml:"logged"`
+}
+
+// readThrottleStatus combines the thermal status of every core and of the
+// package with the perf limit reasons, which tell PL1 from PL2 where supported.
+func readThrottleStatus(dev MSRDevice, msr MSR) (ThrottleStatus, []string) {
+	active, logged := map[string]bool{}, map[string]bool{}
+	var errs []string
+	collect := func(val uint64, bits []throttleBit, logShift uint) {
+		for _, b := range bits {
+			active[b.limiter] = active[b.limiter] || val&(1<<b.bit) != 0
+			logged[b.limiter] = logged[b.limiter] || val&(1<<(b.bit+logShift)) != 0
+		}
+	}
+
+	cpus, err := dev.CPUs()
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	for _, cpu := range cpus {
+		val, err := readMSR(dev, msr.addrThermStatus, cpu)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("thermal status of CPU %d: %v", cpu, err))
+			break
+		}
+		collect(val, thermStatusBits, 1)
+	}
+	if val, err := readMSR(dev, msr.addrPkgThermStatus, 0); err != nil {
+		errs = append(errs, "package thermal status: "+err.Error())
+	} else {
+		collect(val&^(3<<12), thermStatusBits, 1)
+	}
+	if val, err := readMSR(dev, msr.addrPerfLimitReasons, 0); err == nil {
+		// Names the power limit, so the generic bit is redundant.
+		collect(val, perfLimitBits, 16)
+		active[limiterPowerLimit], logged[limiterPowerLimit] = false, false
+	}
+
+	s := ThrottleStatus{Active: []string{}, Logged: []string{}}
+	for _, l := range limiterOrder {
+		if active[l] {
+			s.Active = append(s.Active, l)
+		}
+		if logged[l] {
+			s.Logged = append(s.Logged, l)
+		}
+	}
+	return s, errs
+}
+
+// ---------- Frequency Report ----------
+
+// FreqReport is the output of 'sensors freq'.
+type FreqReport struct {
+	IntervalS  float64         `json:"interval_s" yaml:"interval_s"`
+	CPUs       []CPUFrequency  `json:"cpus" yaml:"cpus"`
+	Throttling *ThrottleStatus `json:"throttling,omitempty" yaml:"throttling,omitempty"`
+	Errors     []string        `json:"errors,omitempty" yaml:"errors,omitempty"`
+}
+
+// measureFreq samples the frequency counters twice, interval apart, and reads the throttle status.
+func measureFreq(dev MSRDevice, devErr error, interval time.Duration) FreqReport {
+	r := FreqReport{IntervalS: interval.Seconds(), CPUs: []CPUFrequency{}}
+	if dev == nil {
+		r.Errors = append(r.Errors, "msr: "+devErr.Error())
+		return r
+	}
+	m, err := newFreqMeter(dev, ADDRESSES)
+	if err != nil {
+		r.Errors = append(r.Errors, "frequency: "+err.Error())
+	} else {
+		time.Sleep(interval)
+		freqs, errs := m.sample()
+		r.CPUs = append(r.CPUs, freqs...)
+		r.Errors = append(r.Errors, errs...)
+	}
+	throttle, errs := readThrottleStatus(dev, ADDRESSES)
+	if len(errs) == 0 || len(throttle.Active)+len(throttle.Logged) > 0 {
+		r.Throttling = &throttle
+	}
+	r.Errors = append(r.Errors, errs...)
+	return r
+}
+
+// formatLimiters prints a list of limiters, "none" when empty.
+func formatLimiters(limiters []string) string {
+	if len(limiters) == 0 {
+		return "none"
+	}
+	return strings.Join(limiters, ", ")
+}
+
+// writeFreqText prints the frequencies as a table, followed by the throttle status.
+func writeFreqText(w io.Writer, r FreqReport) {
+	if len(r.CPUs) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintf(tw, "CPU\tAvg MHz\tBusy %%\tBusy MHz\t\n")
+		for _, f := range r.CPUs {
+			fmt.Fprintf(tw, "%d\t%.0f\t%.1f\t%.0f\t\n", f.CPU, f.AvgMHz, f.BusyPct, f.BusyMHz)
+		}
+		tw.Flush()
+	}
+	if r.Throttling != nil {
+		fmt.Fprintf(w, "Throttled by: %s\n", formatLimiters(r.Throttling.Active))
+		fmt.Fprintf(w, "Throttled since last clear: %s\n", formatLimiters(r.Throttling.Logged))
+	}
+	for _, e := range r.Errors {
+		fmt.Fprintf(w, "Not available: %s\n", e)
+	}
+}
+
+// ---------- Telemetry Session ----------
+
+// telemetryFrame is one reading of everything watch and log show.
+type telemetryFrame struct {
+	Time        time.Time
+	PowerSource string
+	Profile     string // saved profile matching the live settings, "" if none
+	CPUs        []int
+	Temps       map[string]float64 // by TempReading label ("CPU 0", "Package")
+	Freqs       map[int]CPUFrequency
+	Power       []PowerReading
+	Live        *Profile
+	Throttle    *ThrottleStatus
+	Errors      []string
+}
+
+// telemetrySession holds what watch and log keep between readings.
+type telemetrySession struct {
+	dev   MSRDevice
+	msr   MSR
+	cfg   *Config
+	cpus  []int
+	power *powerMeter
+	freq  *freqMeter
+	// Whether a frame was taken; the meters' first readings come from their
+	// constructors, just before the first frame, so that frame ignores them.
+	primed bool
+	// Errors of meters that could not be set up; shown on every frame.
+	setupErrors []string
+}
+
+func newTelemetrySession(dev MSRDevice, msr MSR) *telemetrySession {
+	s := &telemetrySession{dev: dev, msr: msr}
+	var err error
+	if s.cfg, err = loadConfig(); err != nil {
+		s.setupErrors = append(s.setupErrors, "profiles: "+err.Error())
+	}
+	if s.cpus, err = dev.CPUs(); err != nil {
+		s.setupErrors = append(s.setupErrors, "CPUs: "+err.Error())
+	}
+	var errs []string
+	if s.power, errs = newPowerMeter(powercapRoot, dev, nil); s.power == nil {
+		s.setupErrors = append(s.setupErrors, errs...)
+	}
+	if s.freq, err = newFreqMeter(dev, msr); err != nil {
+		s.setupErrors = append(s.setupErrors, "frequency: "+err.Error())
+	}
+	return s
+}
+
+// frame reads the sensors and settings. Power and frequencies are averaged
+// since the previous frame, so the first frame has none.
+func (s *telemetrySession) frame() telemetryFrame {
+	f := telemetryFrame{
+		Time:        time.Now(),
+		PowerSource: currentPowerSource(),
+		CPUs:        s.cpus,
+		Temps:       map[string]float64{},
+		Freqs:       map[int]CPUFrequency{},
+		Errors:      append([]string(nil), s.setupErrors...),
+	}
+	temps, err := readMSRTemps(s.dev, s.msr)
+	if err != nil {
+		f.Errors = append(f.Errors, "temperatures: "+err.Error())
+	}
+	for _, t := range temps {
+		f.Temps[t.Label] = t.Celsius
+	}
+	if s.freq != nil {
+		freqs, errs := s.freq.sample()
+		if s.primed {
+			for _, fr := range freqs {
+				f.Freqs[fr.CPU] = fr
+			}
+		}
+		f.Errors = append(f.Errors, errs...)
+	}
+	if s.power != nil {
+		power, errs := s.power.sample()
+		if s.primed {
+			f.Power = power
+		}
+		f.Errors = append(f.Errors, errs...)
+	}
+	s.primed = true
+	throttle, errs := readThrottleStatus(s.dev, s.msr)
+	if len(errs) == 0 {
+		f.Throttle = &throttle
+	}
+	f.Errors = append(f.Errors, errs...)
+
+	live, unread := readLiveProfile(s.dev, s.msr)
+	f.Live = live
+	var skip []string
+	for _, u := range unread {
+		skip = append(skip, u.Fields...)
+		f.Errors = append(f.Errors, u.String())
+	}
+	if s.cfg != nil {
+		f.Profile = matchingProfile(s.cfg, s.dev, s.msr, live, skip, f.PowerSource)
+	}
+	return f
+}
+
+// matchingProfile returns the saved profile whose settings are all active in
+// live, preferring the one mapped to the power source. Profiles that set
+// nothing match anything and are ignored.
+func matchingProfile(cfg *Config, dev MSRDevice, msr MSR, live *Profile, skip []string, source string) string {
+	names := append([]string{cfg.autoSwitchProfile(source)}, cfg.profileNames()...)
+	for _, name := range names {
+		p, ok := cfg.Profiles[name]
+		if !ok || p == nil {
+			continue
+		}
+		setsAny := false
+		for _, field := range p.fields() {
+			setsAny = setsAny || field.Value != nil
+		}
+		if setsAny && len(diffProfiles(p.asApplied(dev, msr, source), live, true, skip)) == 0 {
+			return name
+		}
+	}
+	return ""
+}
+
+// ---------- Sensors Freq Command ----------
+
+var sensorsFreqCmd = &cobra.Command{
+	Use:         "freq",
+	Annotations: map[string]string{privilegesAnnotation: privilegesMSROptional},
+	Short:       "Show the effective CPU frequencies and which limits throttle the CPU",
+	Long: "Show the effective frequency of every CPU over --interval, from the APERF/MPERF and TSC counters, " +
+		"and which limiters (PL1, PL2, thermal, PROCHOT, current) throttle the CPU now or did since the " +
+		"throttle log was last cleared. Needs root privileges.",
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateOutputFormat(outputFlag); err != nil {
+			return err
+		}
+		if err := checkSensorsInterval(); err != nil {
+			return err
+		}
+		setupLogging()
+		dev, devErr := sensorMSRDevice()
+		r := measureFreq(dev, devErr, sensorsIntervalFlag)
+		if outputFlag != outputText {
+			return encodeOutput(os.Stdout, r, outputFlag)
+		}
+		writeFreqText(os.Stdout, r)
+		return nil
+	},
+}
@@ -0,0 +1,168 @@
+// watch.go
+// Full-screen terminal dashboard of temperatures, frequencies, power, settings and throttling.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// writeWatchFrame prints f as the dashboard text.
+func writeWatchFrame(w io.Writer, f telemetryFrame, interval time.Duration) {
+	fmt.Fprintf(w, "undervolt-go watch - every %v - Ctrl+C to quit    %s\n\n", interval, f.Time.Format("15:04:05"))
+	profile := f.Profile
+	if profile == "" {
+		profile = "none matching"
+	}
+	fmt.Fprintf(w, "Power source: %s    Profile: %s\n", f.PowerSource, profile)
+
+	if len(f.Power) == 0 {
+		fmt.Fprintln(w, "Power: -")
+	} else {
+		var parts []string
+		for _, p := range f.Power {
+			parts = append(parts, fmt.Sprintf("%s %.2f W", p.Domain, p.Watts))
+		}
+		fmt.Fprintf(w, "Power: %s\n", strings.Join(parts, "    "))
+	}
+	if f.Throttle != nil {
+		fmt.Fprintf(w, "Throttled by: %s    (since last clear: %s)\n",
+			formatLimiters(f.Throttle.Active), formatLimiters(f.Throttle.Logged))
+	}
+
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "CPU\tTemp\tAvg MHz\tBusy %%\tBusy MHz\t\n")
+	for _, cpu := range f.CPUs {
+		temp := "-"
+		if c, ok := f.Temps[fmt.Sprintf("CPU %d", cpu)]; ok {
+			temp = fmt.Sprintf("%.0f°C", c)
+		}
+		avg, busy, busyMHz := "-", "-", "-"
+		if fr, ok := f.Freqs[cpu]; ok {
+			avg, busy, busyMHz = fmt.Sprintf("%.0f", fr.AvgMHz), fmt.Sprintf("%.1f", fr.BusyPct), fmt.Sprintf("%.0f", fr.BusyMHz)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t\n", cpu, temp, avg, busy, busyMHz)
+	}
+	if c, ok := f.Temps["Package"]; ok {
+		fmt.Fprintf(tw, "Package\t%.0f°C\t\t\t\t\n", c)
+	}
+	tw.Flush()
+
+	if p := f.Live; p != nil {
+		fmt.Fprintln(w)
+		var offsets []string
+		for _, plane := range planes {
+			if mV, ok := p.Planes[plane.Name]; ok {
+				offsets = append(offsets, fmt.Sprintf("%s %.2f mV", plane.Name, mV))
+			}
+		}
+		fmt.Fprintf(w, "Offsets: %s\n", strings.Join(offsets, ", "))
+		var limits []string
+		for _, t := range []struct {
+			name string
+			pl   *PowerLimitSetting
+		}{{"P1", p.P1}, {"P2", p.P2}} {
+			if t.pl == nil {
+				limits = append(limits, t.name+" disabled")
+			} else {
+				limits = append(limits, fmt.Sprintf("%s %.2fW/%.3gs", t.name, t.pl.PowerW, t.pl.TimeS))
+			}
+		}
+		if p.Lock != nil && *p.Lock {
+			limits = append(limits, "locked")
+		}
+		fmt.Fprintf(w, "Power limits: %s\n", strings.Join(limits, ", "))
+		target := p.Temp
+		if target == nil {
+			target = p.TempBat
+		}
+		turbo := "-"
+		if p.Turbo != nil {
+			turbo = boolToEnabled(*p.Turbo)
+		}
+		fmt.Fprintf(w, "Temperature target: %s    Intel Turbo: %s\n", formatTemp(target), turbo)
+	}
+
+	if len(f.Errors) > 0 {
+		fmt.Fprintln(w)
+		for _, e := range f.Errors {
+			fmt.Fprintf(w, "Not available: %s\n", e)
+		}
+	}
+}
+
+// isTerminal reports whether f is a character device, i.e. most likely a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// ---------- Watch Command ----------
+
+// ANSI sequences for the dashboard: alternate screen, cursor visibility, clear.
+const (
+	ansiEnterScreen = "\x1b[?1049h\x1b[?25l"
+	ansiLeaveScreen = "\x1b[?25h\x1b[?1049l"
+	ansiClear       = "\x1b[H\x1b[2J"
+)
+
+var watchIntervalFlag time.Duration
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Live terminal dashboard of temperatures, frequencies, power, settings and throttling",
+	Long: "Show a full-screen terminal dashboard, refreshed every --interval, with the temperature and " +
+		"effective frequency of every CPU, the RAPL power, the active voltage offsets, power limits and " +
+		"temperature target, the limiters throttling the CPU, the power source and the saved profile " +
+		"matching the live settings. When standard output is not a terminal, the frames are printed one " +
+		"after another.",
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if watchIntervalFlag < 100*time.Millisecond {
+			return fmt.Errorf("--interval must be at least 100ms, got %v", watchIntervalFlag)
+		}
+		setupLogging()
+		s := newTelemetrySession(openMSRDevice(), ADDRESSES)
+
+		tty := isTerminal(os.Stdout)
+		if tty {
+			fmt.Print(ansiEnterScreen)
+			defer fmt.Print(ansiLeaveScreen)
+		}
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(stop)
+		ticker := time.NewTicker(watchIntervalFlag)
+		defer ticker.Stop()
+
+		for {
+			// Render into a buffer and write it at once to avoid flicker.
+			var buf bytes.Buffer
+			if tty {
+				buf.WriteString(ansiClear)
+			}
+			writeWatchFrame(&buf, s.frame(), watchIntervalFlag)
+			if !tty {
+				buf.WriteString("\n")
+			}
+			os.Stdout.Write(buf.Bytes())
+
+			select {
+			case <-stop:
+				return nil
+			case <-ticker.C:
+			}
+		}
+	},
+}